@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/natenho/go-jira-migrate/migration"
+	"github.com/pkg/errors"
+)
+
+const configVersion = 1
+
+// Config holds every option of a migration, so it can be versioned next to the migration runbooks.
+// Command line flags override the values read from the file.
+type Config struct {
+	Version       int               `json:"version"`
+	Source        ConnectionConfig  `json:"source"`
+	Target        ConnectionConfig  `json:"target"`
+	User          string            `json:"user,omitempty"`
+	APIKey        string            `json:"apiKey,omitempty"`
+	Ledger        string            `json:"ledger,omitempty"`
+	Query         string            `json:"query,omitempty"`
+	Projects      []ProjectConfig   `json:"projects,omitempty"`
+	Fields        FieldsConfig      `json:"fields"`
+	Users         map[string]string `json:"users,omitempty"`
+	UserMapping   UserMappingConfig `json:"userMapping"`
+	Statuses      map[string]string `json:"statuses,omitempty"`
+	Visibilities  map[string]string `json:"visibilities,omitempty"`
+	Labels        LabelsConfig      `json:"labels"`
+	References    ReferencesConfig  `json:"references"`
+	ADF           bool              `json:"adf"`
+	History       []string          `json:"history,omitempty"`
+	Sprints       bool              `json:"sprints"`
+	DeleteOnError bool              `json:"deleteOnError"`
+	Workers       WorkersConfig     `json:"workers"`
+	RateLimit     RateLimitConfig   `json:"rateLimit"`
+	Webhook       WebhookConfig     `json:"webhook"`
+}
+
+// ConnectionConfig is where and how to connect to an instance, the user and API key default to the top level ones
+type ConnectionConfig struct {
+	URL    string `json:"url,omitempty"`
+	Auth   string `json:"auth,omitempty"`
+	User   string `json:"user,omitempty"`
+	APIKey string `json:"apiKey,omitempty"`
+}
+
+// connectionEnvironment lists the environment variables read into the connections, overridden by the flags
+var connectionEnvironment = []struct {
+	name  string
+	field func(cfg *Config) *string
+}{
+	{"JIRA_SOURCE_URL", func(cfg *Config) *string { return &cfg.Source.URL }},
+	{"JIRA_SOURCE_AUTH", func(cfg *Config) *string { return &cfg.Source.Auth }},
+	{"JIRA_SOURCE_USER", func(cfg *Config) *string { return &cfg.Source.User }},
+	{"JIRA_SOURCE_API_KEY", func(cfg *Config) *string { return &cfg.Source.APIKey }},
+	{"JIRA_TARGET_URL", func(cfg *Config) *string { return &cfg.Target.URL }},
+	{"JIRA_TARGET_AUTH", func(cfg *Config) *string { return &cfg.Target.Auth }},
+	{"JIRA_TARGET_USER", func(cfg *Config) *string { return &cfg.Target.User }},
+	{"JIRA_TARGET_API_KEY", func(cfg *Config) *string { return &cfg.Target.APIKey }},
+}
+
+// ProjectConfig is a pair of projects to migrate, the query defaults to the top level one
+type ProjectConfig struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Query  string `json:"query,omitempty"`
+}
+
+type FieldsConfig struct {
+	// Migrate lists the custom fields read from the source project
+	Migrate []string `json:"migrate,omitempty"`
+	// Mappings migrates source fields to target fields with other names
+	Mappings []FieldMappingConfig `json:"mappings,omitempty"`
+	// Values sets fixed values on target fields whenever the source field has any value
+	Values []FieldValueConfig `json:"values,omitempty"`
+	// Original names the target fields filled with the original dates and reporter of the source issues
+	Original OriginalFieldsConfig `json:"original"`
+}
+
+// OriginalFieldsConfig names the target custom fields of the source values Jira sets on its own
+type OriginalFieldsConfig struct {
+	// Created, Updated and Resolved are date-time or date pickers
+	Created  string `json:"created,omitempty"`
+	Updated  string `json:"updated,omitempty"`
+	Resolved string `json:"resolved,omitempty"`
+	// Reporter is a user picker
+	Reporter string `json:"reporter,omitempty"`
+}
+
+type FieldMappingConfig struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+type FieldValueConfig struct {
+	Field string      `json:"field"`
+	Value interface{} `json:"value"`
+}
+
+// UserMappingConfig finds the target users of the source users missing from Users
+type UserMappingConfig struct {
+	// File is a CSV file with source and target columns, or a JSON object like Users
+	File string `json:"file,omitempty"`
+	// Match searches the target users by "email" or "displayName", in order
+	Match []string `json:"match,omitempty"`
+	// Report is a CSV file listing the source users without an active target user
+	Report string `json:"report,omitempty"`
+}
+
+// ReferencesConfig rewrites the source issue keys and links found in the migrated text once the issues are created
+type ReferencesConfig struct {
+	Rewrite bool `json:"rewrite"`
+	// Audit is a JSON Lines file recording every reference rewritten
+	Audit string `json:"audit,omitempty"`
+}
+
+type LabelsConfig struct {
+	Add    []string          `json:"add,omitempty"`
+	Rename map[string]string `json:"rename,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+type WorkersConfig struct {
+	Count int `json:"count"`
+	// Adaptive lets the migration change the number of workers between Min and Max, instead of using Count
+	Adaptive bool `json:"adaptive,omitempty"`
+	Min      int  `json:"min,omitempty"`
+	Max      int  `json:"max,omitempty"`
+}
+
+// RateLimitConfig paces the requests of all workers to each instance, the throttled requests are retried
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	MaxRetries        int     `json:"maxRetries"`
+}
+
+type WebhookConfig struct {
+	Listen string `json:"listen,omitempty"`
+	// Secret is shared with the source instance, which signs the webhook payloads with it
+	Secret string `json:"secret,omitempty"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Version: configVersion,
+		Ledger:  "go-jira-migrate.ledger",
+		Query:   "Status != Done",
+		Fields: FieldsConfig{
+			Migrate: []string{"Story point estimate", "Story Points", "Flagged"},
+			Mappings: []FieldMappingConfig{
+				{Source: "Story Points", Target: "Story point estimate"},
+			},
+			Values: []FieldValueConfig{
+				{Field: "Flagged", Value: []interface{}{map[string]interface{}{"value": "Impediment"}}},
+			},
+		},
+		Labels:     LabelsConfig{Add: []string{"MIGRATED"}},
+		References: ReferencesConfig{Audit: "go-jira-migrate.references.jsonl"},
+		Sprints:    true,
+		Workers:    WorkersConfig{Count: defaultWorkerPoolSize},
+		RateLimit:  RateLimitConfig{RequestsPerSecond: migration.DefaultRequestsPerSecond, MaxRetries: migration.DefaultMaxRetries},
+		Webhook:    WebhookConfig{Listen: ":8080"},
+	}
+}
+
+// loadConfig reads the config file over the defaults, values missing from the file keep their defaults
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path == "" {
+		return cfg, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read config")
+	}
+
+	cfg.Version = 0
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, describeConfigError(path, content, err)
+	}
+
+	if cfg.Version != configVersion {
+		return nil, errors.Errorf("%s: version: must be %d, found %d", path, configVersion, cfg.Version)
+	}
+
+	for _, connection := range []*ConnectionConfig{&cfg.Source, &cfg.Target} {
+		connection.URL = os.ExpandEnv(connection.URL)
+		connection.User = os.ExpandEnv(connection.User)
+		connection.APIKey = os.ExpandEnv(connection.APIKey)
+	}
+
+	cfg.User = os.ExpandEnv(cfg.User)
+	cfg.APIKey = os.ExpandEnv(cfg.APIKey)
+	cfg.Webhook.Secret = os.ExpandEnv(cfg.Webhook.Secret)
+
+	return cfg, nil
+}
+
+// loadEnvironment reads the connections from the environment variables that are set
+func (c *Config) loadEnvironment() {
+	for _, variable := range connectionEnvironment {
+		if value, ok := os.LookupEnv(variable.name); ok {
+			*variable.field(c) = value
+		}
+	}
+}
+
+func describeConfigError(path string, content []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		line, column := getLineAndColumn(content, syntaxErr.Offset)
+		return errors.Errorf("%s:%d:%d: %s", path, line, column, syntaxErr)
+	case errors.As(err, &typeErr):
+		line, column := getLineAndColumn(content, typeErr.Offset)
+		return errors.Errorf("%s:%d:%d: %s: expected %s, found %s", path, line, column, typeErr.Field, typeErr.Type, typeErr.Value)
+	default:
+		return errors.Errorf("%s: %s", path, strings.TrimPrefix(err.Error(), "json: "))
+	}
+}
+
+func getLineAndColumn(content []byte, offset int64) (line, column int) {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+
+	before := content[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = int(offset) - bytes.LastIndexByte(before, '\n')
+
+	return line, column
+}
+
+// validateConnection checks the options required to connect to both instances
+func (c *Config) validateConnection() error {
+	var problems []string
+
+	problems = append(problems, validateConnectionConfig("source", c.sourceConnection())...)
+	problems = append(problems, validateConnectionConfig("target", c.targetConnection())...)
+
+	if c.RateLimit.RequestsPerSecond < 0 {
+		problems = append(problems, fmt.Sprintf("rateLimit.requestsPerSecond: cannot be negative, found %g (-rate)", c.RateLimit.RequestsPerSecond))
+	}
+
+	if c.RateLimit.MaxRetries < 0 {
+		problems = append(problems, fmt.Sprintf("rateLimit.maxRetries: cannot be negative, found %d (-max-retries)", c.RateLimit.MaxRetries))
+	}
+
+	return joinProblems(problems)
+}
+
+// validateMigration checks the options required to migrate or plan the projects
+func (c *Config) validateMigration() error {
+	var problems []string
+
+	if len(c.Projects) == 0 {
+		problems = append(problems, "projects: at least one project pair is required (-source-project and -target-project)")
+	}
+
+	for i, project := range c.Projects {
+		if project.Source == "" {
+			problems = append(problems, fmt.Sprintf("projects[%d].source: is required", i))
+		}
+
+		if project.Target == "" {
+			problems = append(problems, fmt.Sprintf("projects[%d].target: is required", i))
+		}
+
+		if project.Query == "" && c.Query == "" {
+			problems = append(problems, fmt.Sprintf("projects[%d].query: is required when there is no top level query (-query)", i))
+		}
+	}
+
+	for i, mapping := range c.Fields.Mappings {
+		if mapping.Source == "" || mapping.Target == "" {
+			problems = append(problems, fmt.Sprintf("fields.mappings[%d]: source and target are required", i))
+		}
+	}
+
+	for i, value := range c.Fields.Values {
+		if value.Field == "" {
+			problems = append(problems, fmt.Sprintf("fields.values[%d].field: is required", i))
+		}
+
+		if value.Value == nil {
+			problems = append(problems, fmt.Sprintf("fields.values[%d].value: is required", i))
+		}
+	}
+
+	for sourceUserID, targetUserID := range c.Users {
+		if sourceUserID == "" || targetUserID == "" {
+			problems = append(problems, fmt.Sprintf("users: %q cannot be mapped to %q, account IDs and user names cannot be empty", sourceUserID, targetUserID))
+		}
+	}
+
+	for i, matchBy := range c.UserMapping.Match {
+		if matchBy != migration.MatchUsersByEmail && matchBy != migration.MatchUsersByDisplayName {
+			problems = append(problems, fmt.Sprintf("userMapping.match[%d]: must be %q or %q, found %q (-match-users)", i, migration.MatchUsersByEmail, migration.MatchUsersByDisplayName, matchBy))
+		}
+	}
+
+	for sourceStatus, targetStatus := range c.Statuses {
+		if sourceStatus == "" || targetStatus == "" {
+			problems = append(problems, fmt.Sprintf("statuses: %q cannot be mapped to %q, status names cannot be empty", sourceStatus, targetStatus))
+		}
+	}
+
+	for i, format := range c.History {
+		if format != migration.HistoryComment && format != migration.HistoryJSON && format != migration.HistoryCSV {
+			problems = append(problems, fmt.Sprintf("history[%d]: must be %q, %q or %q, found %q (-history)", i, migration.HistoryComment, migration.HistoryJSON, migration.HistoryCSV, format))
+		}
+	}
+
+	for sourceName, targetName := range c.Visibilities {
+		if sourceName == "" || targetName == "" {
+			problems = append(problems, fmt.Sprintf("visibilities: %q cannot be mapped to %q, role and group names cannot be empty", sourceName, targetName))
+		}
+	}
+
+	if c.References.Rewrite && c.References.Audit == "" {
+		problems = append(problems, "references.audit: is required to rewrite references (-reference-audit)")
+	}
+
+	if c.Workers.Count <= 0 {
+		problems = append(problems, fmt.Sprintf("workers.count: must be greater than zero, found %d (-workers)", c.Workers.Count))
+	}
+
+	if c.Workers.Adaptive && c.Workers.Min <= 0 {
+		problems = append(problems, fmt.Sprintf("workers.min: must be greater than zero, found %d (-min-workers)", c.Workers.Min))
+	}
+
+	if c.Workers.Adaptive && c.Workers.Max < c.Workers.Min {
+		problems = append(problems, fmt.Sprintf("workers.max: must not be less than workers.min %d, found %d (-max-workers)", c.Workers.Min, c.Workers.Max))
+	}
+
+	return joinProblems(problems)
+}
+
+// validateConnectionConfig converts the problems of the connection into problems of the configuration, with the
+// flags setting them
+func validateConnectionConfig(side string, connection migration.Connection) []string {
+	flags := map[string]string{
+		"url":    fmt.Sprintf("-%s", side),
+		"auth":   fmt.Sprintf("-%s-auth", side),
+		"user":   fmt.Sprintf("-%s-user or -user", side),
+		"apiKey": fmt.Sprintf("-%s-api-key or -api-key", side),
+	}
+
+	var problems []string
+	for _, problem := range connection.Validate() {
+		problems = append(problems, fmt.Sprintf("%s.%s: %s (%s)", side, problem.Setting, problem.Message, flags[problem.Setting]))
+	}
+
+	return problems
+}
+
+func joinProblems(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return errors.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// newTransport creates the transport shared by the migrators of a command, so the rate limit applies to all of them
+func (c *Config) newTransport() *migration.Transport {
+	return migration.NewTransport(c.RateLimit.RequestsPerSecond, c.RateLimit.MaxRetries)
+}
+
+// migrationOptions returns the migrator options defined by the configuration
+func (c *Config) migrationOptions() []migration.Option {
+	options := []migration.Option{
+		migration.WithWorkerPoolSize(c.Workers.Count),
+		migration.WithAdditionalLabels(c.Labels.Add...),
+		migration.WithoutLabels(c.Labels.Remove...),
+		migration.WithCustomFields(c.Fields.Migrate...),
+		migration.WithSprints(c.Sprints),
+		migration.WithADF(c.ADF),
+		migration.WithHistory(c.History...),
+		migration.WithDeleteOnError(c.DeleteOnError),
+	}
+
+	if c.Workers.Adaptive {
+		options = append(options, migration.WithAdaptiveWorkers(c.Workers.Min, c.Workers.Max))
+	}
+
+	for _, mapping := range c.Fields.Mappings {
+		options = append(options, migration.WithFieldMapping(mapping.Source, mapping.Target))
+	}
+
+	for _, value := range c.Fields.Values {
+		options = append(options, migration.WithFieldValue(value.Field, value.Value))
+	}
+
+	for value, targetFieldName := range map[string]string{
+		migration.OriginalCreated:  c.Fields.Original.Created,
+		migration.OriginalUpdated:  c.Fields.Original.Updated,
+		migration.OriginalResolved: c.Fields.Original.Resolved,
+		migration.OriginalReporter: c.Fields.Original.Reporter,
+	} {
+		if targetFieldName != "" {
+			options = append(options, migration.WithOriginalField(value, targetFieldName))
+		}
+	}
+
+	for sourceLabel, targetLabel := range c.Labels.Rename {
+		options = append(options, migration.WithLabelRename(sourceLabel, targetLabel))
+	}
+
+	for sourceUserID, targetUserID := range c.Users {
+		options = append(options, migration.WithUserMapping(sourceUserID, targetUserID))
+	}
+
+	if c.References.Rewrite {
+		options = append(options, migration.WithReferenceRewriting(c.References.Audit))
+	}
+
+	if len(c.UserMapping.Match) > 0 {
+		options = append(options, migration.WithUserMatching(c.UserMapping.Match...))
+	}
+
+	for sourceStatus, targetStatus := range c.Statuses {
+		options = append(options, migration.WithStatusMapping(sourceStatus, targetStatus))
+	}
+
+	for sourceName, targetName := range c.Visibilities {
+		options = append(options, migration.WithVisibilityMapping(sourceName, targetName))
+	}
+
+	return options
+}
+
+func (c *Config) sourceConnection() migration.Connection {
+	return c.Source.connection(c)
+}
+
+func (c *Config) targetConnection() migration.Connection {
+	return c.Target.connection(c)
+}
+
+// connection completes the connection with the top level user and API key
+func (cc ConnectionConfig) connection(c *Config) migration.Connection {
+	connection := migration.Connection{URL: cc.URL, AuthType: cc.Auth, User: cc.User, Token: cc.APIKey}
+
+	if connection.AuthType == "" {
+		connection.AuthType = migration.AuthBasic
+	}
+
+	if connection.User == "" {
+		connection.User = c.User
+	}
+
+	if connection.Token == "" {
+		connection.Token = c.APIKey
+	}
+
+	return connection
+}
+
+func (p ProjectConfig) query(c *Config) string {
+	if p.Query != "" {
+		return p.Query
+	}
+
+	return c.Query
+}
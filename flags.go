@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/natenho/go-jira-migrate/migration"
+)
+
+// configFlags binds command line flags to configuration values.
+// Flags given explicitly override the values read from the config file.
+type configFlags struct {
+	flags      *flag.FlagSet
+	configPath *string
+	defaults   *Config
+	overrides  map[string]func(cfg *Config)
+}
+
+func newConfigFlags(flags *flag.FlagSet) *configFlags {
+	return &configFlags{
+		flags:      flags,
+		configPath: flags.String("config", "", "JSON file with the migration options, overridden by the command line flags"),
+		defaults:   defaultConfig(),
+		overrides:  map[string]func(cfg *Config){},
+	}
+}
+
+func (c *configFlags) String(name, usage string, field func(cfg *Config) *string) {
+	value := c.flags.String(name, *field(c.defaults), usage)
+	c.overrides[name] = func(cfg *Config) { *field(cfg) = *value }
+}
+
+func (c *configFlags) Bool(name, usage string, field func(cfg *Config) *bool) {
+	value := c.flags.Bool(name, *field(c.defaults), usage)
+	c.overrides[name] = func(cfg *Config) { *field(cfg) = *value }
+}
+
+func (c *configFlags) Int(name, usage string, field func(cfg *Config) *int) {
+	value := c.flags.Int(name, *field(c.defaults), usage)
+	c.overrides[name] = func(cfg *Config) { *field(cfg) = *value }
+}
+
+func (c *configFlags) Float(name, usage string, field func(cfg *Config) *float64) {
+	value := c.flags.Float64(name, *field(c.defaults), usage)
+	c.overrides[name] = func(cfg *Config) { *field(cfg) = *value }
+}
+
+// Strings binds a repeatable flag, whose values are added to the configured ones
+func (c *configFlags) Strings(name, usage string, field func(cfg *Config) *[]string) {
+	var values flagStringArray
+	c.flags.Var(&values, name, usage)
+	c.overrides[name] = func(cfg *Config) { *field(cfg) = append(*field(cfg), values...) }
+}
+
+// Parse parses the arguments and returns the config file values overridden by the flags given explicitly
+func (c *configFlags) Parse(args []string) (*Config, error) {
+	_ = c.flags.Parse(args)
+
+	cfg, err := loadConfig(*c.configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.loadEnvironment()
+
+	c.flags.Visit(func(f *flag.Flag) {
+		if override, ok := c.overrides[f.Name]; ok {
+			override(cfg)
+		}
+	})
+
+	if err := cfg.loadUserMappingFile(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *configFlags) addLedgerFlag() {
+	c.String("ledger", "File recording which issues were already migrated", func(cfg *Config) *string { return &cfg.Ledger })
+}
+
+// addConnectionFlags adds the flags shared by every command talking to the JIRA instances
+func (c *configFlags) addConnectionFlags() {
+	c.String("source", "Source JIRA URL (e.g. https://your-source-domain.atlassian.net/)", func(cfg *Config) *string { return &cfg.Source.URL })
+	c.String("target", "Target JIRA URL (e.g. https://your-target-domain.atlassian.net/)", func(cfg *Config) *string { return &cfg.Target.URL })
+	c.String("user", "User of both instances, unless -source-user or -target-user are given", func(cfg *Config) *string { return &cfg.User })
+	c.String("api-key", "API Key of both instances, unless -source-api-key or -target-api-key are given (to create one, visit https://tinyurl.com/jira-api-token/)", func(cfg *Config) *string { return &cfg.APIKey })
+	c.String("source-auth", "Source auth type, 'basic' (user and API key or password) or 'bearer' (personal access token)", func(cfg *Config) *string { return &cfg.Source.Auth })
+	c.String("source-user", "Source user", func(cfg *Config) *string { return &cfg.Source.User })
+	c.String("source-api-key", "Source API key, password or personal access token", func(cfg *Config) *string { return &cfg.Source.APIKey })
+	c.String("target-auth", "Target auth type, 'basic' (user and API key or password) or 'bearer' (personal access token)", func(cfg *Config) *string { return &cfg.Target.Auth })
+	c.String("target-user", "Target user", func(cfg *Config) *string { return &cfg.Target.User })
+	c.String("target-api-key", "Target API key, password or personal access token", func(cfg *Config) *string { return &cfg.Target.APIKey })
+	c.Float("rate", "Requests per second to each JIRA instance, shared by all workers (0 for no limit)", func(cfg *Config) *float64 { return &cfg.RateLimit.RequestsPerSecond })
+	c.Int("max-retries", "How many times a request throttled by JIRA (429 or 503) is retried", func(cfg *Config) *int { return &cfg.RateLimit.MaxRetries })
+	c.addLedgerFlag()
+}
+
+// addProjectFlags adds the flags selecting what is migrated, which replace the project pairs of the config file
+func (c *configFlags) addProjectFlags() {
+	var sourceProjectKey = c.flags.String("source-project", "", "Source project key (e.g. MYPROJ)")
+	var targetProjectKey = c.flags.String("target-project", "", "Target project key (e.g. OTHER)")
+
+	overrideProject := func(cfg *Config) {
+		project := ProjectConfig{}
+		if len(cfg.Projects) > 0 {
+			project = cfg.Projects[0]
+		}
+
+		if *sourceProjectKey != "" {
+			project.Source = *sourceProjectKey
+		}
+
+		if *targetProjectKey != "" {
+			project.Target = *targetProjectKey
+		}
+
+		cfg.Projects = []ProjectConfig{project}
+	}
+
+	c.overrides["source-project"] = overrideProject
+	c.overrides["target-project"] = overrideProject
+
+	var jql = c.flags.String("query", c.defaults.Query, "JQL query returning issues to be migrated from the selected project (e.g. \"status != Done\" to migrate only pending issues)")
+	c.overrides["query"] = func(cfg *Config) {
+		cfg.Query = *jql
+		for i := range cfg.Projects {
+			cfg.Projects[i].Query = ""
+		}
+	}
+}
+
+// addMigrationFlags adds the flags changing how issues are migrated
+func (c *configFlags) addMigrationFlags() {
+	c.Int("workers", "How many migrations should occur in parallel", func(cfg *Config) *int { return &cfg.Workers.Count })
+	c.Bool("adaptive-workers", "Change the number of workers between -min-workers and -max-workers, adding workers while the throughput improves and removing them when requests are throttled or slower", func(cfg *Config) *bool { return &cfg.Workers.Adaptive })
+	c.Int("min-workers", "Minimum number of workers, and the initial one, with -adaptive-workers", func(cfg *Config) *int { return &cfg.Workers.Min })
+	c.Int("max-workers", "Maximum number of workers with -adaptive-workers", func(cfg *Config) *int { return &cfg.Workers.Max })
+	c.Bool("sprints", "Define if sprints will be imported", func(cfg *Config) *bool { return &cfg.Sprints })
+	c.Strings("field", "Custom fields to read from source project (includes 'Story point estimate' and 'Flagged' by default)", func(cfg *Config) *[]string { return &cfg.Fields.Migrate })
+	c.String("original-created-field", "Target date-time or date picker filled with the creation date of the source issue (e.g. \"Original Created\")", func(cfg *Config) *string { return &cfg.Fields.Original.Created })
+	c.String("original-updated-field", "Target date-time or date picker filled with the last update date of the source issue", func(cfg *Config) *string { return &cfg.Fields.Original.Updated })
+	c.String("original-resolved-field", "Target date-time or date picker filled with the resolution date of the source issue", func(cfg *Config) *string { return &cfg.Fields.Original.Resolved })
+	c.String("original-reporter-field", "Target user picker filled with the reporter of the source issue", func(cfg *Config) *string { return &cfg.Fields.Original.Reporter })
+	c.String("users-file", "CSV file with source and target columns, or JSON object, mapping source users to target users", func(cfg *Config) *string { return &cfg.UserMapping.File })
+	c.Strings("match-users", "Find the target users of unmapped source users by 'email' or 'displayName' (repeatable, tried in order)", func(cfg *Config) *[]string { return &cfg.UserMapping.Match })
+	c.String("unmapped-users", "CSV file listing the source users without a target user, which can be completed and used as -users-file", func(cfg *Config) *string { return &cfg.UserMapping.Report })
+	c.Bool("rewrite-references", "Replace the source issue keys and links found in summaries, descriptions and comments by the target ones, once the issues are created", func(cfg *Config) *bool { return &cfg.References.Rewrite })
+	c.Bool("adf", "Migrate descriptions and comments as Atlassian Document Format through the REST API v3, keeping tables, panels, mentions and media (Cloud to Cloud only)", func(cfg *Config) *bool { return &cfg.ADF })
+	c.Strings("history", "Export the change history of the source issues to the target issues as a 'comment', a 'json' or a 'csv' attachment (repeatable)", func(cfg *Config) *[]string { return &cfg.History })
+	c.String("reference-audit", "JSON Lines file recording every issue reference rewritten", func(cfg *Config) *string { return &cfg.References.Audit })
+	c.Strings("label", "Additional labels to assign to migrated issues (includes 'MIGRATED' label) by default", func(cfg *Config) *[]string { return &cfg.Labels.Add })
+}
+
+// newMigrator creates a migrator between the projects recording its work in the ledger, its requests paced by the
+// transport shared with the other migrators of the command
+func newMigrator(cfg *Config, ledger *migration.Ledger, transport *migration.Transport, project ProjectConfig, options ...migration.Option) (migration.Migrator, error) {
+	options = append(cfg.migrationOptions(), options...)
+	options = append(options, migration.WithLedger(ledger), migration.WithTransport(transport))
+
+	return migration.NewMigrator(
+		cfg.sourceConnection(),
+		cfg.targetConnection(),
+		project.Source,
+		project.Target,
+		options...,
+	)
+}
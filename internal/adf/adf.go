@@ -0,0 +1,172 @@
+// Package adf models the Atlassian Document Format, the JSON documents of the descriptions and comments of the
+// Jira Cloud REST API v3.
+package adf
+
+// Node is a node of a document, the document itself being the node of type doc
+type Node struct {
+	Type    string                 `json:"type"`
+	Version int                    `json:"version,omitempty"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+	Content []*Node                `json:"content,omitempty"`
+	Text    string                 `json:"text,omitempty"`
+	Marks   []*Mark                `json:"marks,omitempty"`
+}
+
+// Mark formats a text node, as a link, emphasis or color
+type Mark struct {
+	Type  string                 `json:"type"`
+	Attrs map[string]interface{} `json:"attrs,omitempty"`
+}
+
+const (
+	TypeDoc         = "doc"
+	TypeParagraph   = "paragraph"
+	TypeText        = "text"
+	TypeMention     = "mention"
+	TypeInlineCard  = "inlineCard"
+	TypeBlockCard   = "blockCard"
+	TypeMedia       = "media"
+	TypeMediaSingle = "mediaSingle"
+	TypeMediaGroup  = "mediaGroup"
+	TypeRule        = "rule"
+	TypeExpand      = "expand"
+	TypeTable       = "table"
+	TypeTableRow    = "tableRow"
+	TypeTableHeader = "tableHeader"
+	TypeTableCell   = "tableCell"
+
+	MarkLink      = "link"
+	MarkEm        = "em"
+	MarkTextColor = "textColor"
+)
+
+// Doc returns a document with the given content
+func Doc(content ...*Node) *Node {
+	return &Node{Type: TypeDoc, Version: 1, Content: content}
+}
+
+func Paragraph(content ...*Node) *Node {
+	return &Node{Type: TypeParagraph, Content: content}
+}
+
+func Text(text string, marks ...*Mark) *Node {
+	return &Node{Type: TypeText, Text: text, Marks: marks}
+}
+
+// Mention returns a mention of a user by account ID, text being the name displayed when the user cannot be read
+func Mention(accountID, text string) *Node {
+	return &Node{Type: TypeMention, Attrs: map[string]interface{}{"id": accountID, "text": text}}
+}
+
+func InlineCard(url string) *Node {
+	return &Node{Type: TypeInlineCard, Attrs: map[string]interface{}{"url": url}}
+}
+
+func Rule() *Node {
+	return &Node{Type: TypeRule}
+}
+
+// Expand returns a section collapsed under its title
+func Expand(title string, content ...*Node) *Node {
+	return &Node{Type: TypeExpand, Attrs: map[string]interface{}{"title": title}, Content: content}
+}
+
+func Table(rows ...*Node) *Node {
+	return &Node{Type: TypeTable, Content: rows}
+}
+
+func TableRow(cells ...*Node) *Node {
+	return &Node{Type: TypeTableRow, Content: cells}
+}
+
+// TableHeader returns a header cell, whose content are paragraphs or other blocks
+func TableHeader(content ...*Node) *Node {
+	return &Node{Type: TypeTableHeader, Content: content}
+}
+
+// TableCell returns a cell, whose content are paragraphs or other blocks
+func TableCell(content ...*Node) *Node {
+	return &Node{Type: TypeTableCell, Content: content}
+}
+
+func Link(href string) *Mark {
+	return &Mark{Type: MarkLink, Attrs: map[string]interface{}{"href": href}}
+}
+
+func Em() *Mark {
+	return &Mark{Type: MarkEm}
+}
+
+// TextColor colors a text, color being a hex color such as #ff5630
+func TextColor(color string) *Mark {
+	return &Mark{Type: MarkTextColor, Attrs: map[string]interface{}{"color": color}}
+}
+
+// Attr returns a string attribute of the node, or an empty string
+func (n *Node) Attr(name string) string {
+	value, _ := n.Attrs[name].(string)
+	return value
+}
+
+func (n *Node) SetAttr(name string, value interface{}) {
+	if n.Attrs == nil {
+		n.Attrs = map[string]interface{}{}
+	}
+
+	n.Attrs[name] = value
+}
+
+// Attr returns a string attribute of the mark, or an empty string
+func (m *Mark) Attr(name string) string {
+	value, _ := m.Attrs[name].(string)
+	return value
+}
+
+func (m *Mark) SetAttr(name string, value interface{}) {
+	if m.Attrs == nil {
+		m.Attrs = map[string]interface{}{}
+	}
+
+	m.Attrs[name] = value
+}
+
+// Append adds nodes at the end of the content of the node
+func (n *Node) Append(content ...*Node) {
+	n.Content = append(n.Content, content...)
+}
+
+// Prepend adds nodes at the beginning of the content of the node
+func (n *Node) Prepend(content ...*Node) {
+	n.Content = append(append([]*Node{}, content...), n.Content...)
+}
+
+// Walk visits the node and its descendants depth-first, skipping the descendants of a node when visit returns false
+func (n *Node) Walk(visit func(node *Node) bool) {
+	if n == nil || !visit(n) {
+		return
+	}
+
+	for _, child := range n.Content {
+		child.Walk(visit)
+	}
+}
+
+// Transform replaces every descendant of the node by the nodes returned by replace, after transforming its own
+// descendants. Returning the node itself keeps it, returning nothing removes it.
+func (n *Node) Transform(replace func(node *Node) []*Node) {
+	if n == nil || len(n.Content) == 0 {
+		return
+	}
+
+	content := make([]*Node, 0, len(n.Content))
+	for _, child := range n.Content {
+		if child == nil {
+			continue
+		}
+
+		child.Transform(replace)
+		content = append(content, replace(child)...)
+	}
+
+	n.Content = content
+}
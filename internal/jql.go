@@ -4,30 +4,21 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 )
 
 func SanitizeJQL(projectKey, jql string) string {
-	jql = regexp.MustCompile(`(?i)project\s?=\s?`).ReplaceAllString(jql, "")
-	jql = regexp.MustCompile(`(?i)order\s+by.*`).ReplaceAllString(jql, "")
-
-	return fmt.Sprintf("project = %s AND (%s) ORDER BY key ASC", projectKey, strings.TrimSpace(jql))
+	return fmt.Sprintf("project = %s AND (%s) ORDER BY key ASC", projectKey, sanitizeCondition(jql))
 }
 
-func SanitizeTermForJQL(input string) string {
-	input = strings.ReplaceAll(input, " - ", " ")
-	input = strings.ReplaceAll(input, "- ", " ")
-	input = strings.ReplaceAll(input, " -", " ")
-	input = strings.ReplaceAll(input, "\t", "\\t")
-	input = strings.ReplaceAll(input, `\`, `\\`)
-
-	const notAllowedSearchChars string = `{}-["]()?*+`
+// SanitizeJQLUpdatedSince restricts the query to issues updated since the given time, formatted in its location
+func SanitizeJQLUpdatedSince(projectKey, jql string, since time.Time) string {
+	return fmt.Sprintf("project = %s AND (%s) AND updated >= \"%s\" ORDER BY key ASC", projectKey, sanitizeCondition(jql), since.Format("2006/01/02 15:04"))
+}
 
-	filter := func(r rune) rune {
-		if strings.ContainsRune(notAllowedSearchChars, r) {
-			return ' '
-		}
-		return r
-	}
+func sanitizeCondition(jql string) string {
+	jql = regexp.MustCompile(`(?i)project\s?=\s?`).ReplaceAllString(jql, "")
+	jql = regexp.MustCompile(`(?i)order\s+by.*`).ReplaceAllString(jql, "")
 
-	return strings.Map(filter, input)
+	return strings.TrimSpace(jql)
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/natenho/go-jira-migrate/migration"
+	"github.com/pkg/errors"
+)
+
+func runLookup(flags *flag.FlagSet, args []string) error {
+	configFlags := newConfigFlags(flags)
+	configFlags.addLedgerFlag()
+
+	cfg, err := configFlags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no issue key given")
+	}
+
+	ledger, err := migration.OpenLedger(cfg.Ledger)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+
+	entries := ledger.Entries()
+
+	var notFound []string
+	for _, key := range flags.Args() {
+		key = strings.ToUpper(strings.TrimSpace(key))
+
+		if targetKey, ok := ledger.TargetKey(key); ok {
+			fmt.Printf("%s -> %s\n", key, targetKey)
+			continue
+		}
+
+		found := false
+		for _, entry := range entries {
+			if entry.TargetKey == key {
+				fmt.Printf("%s <- %s\n", key, entry.SourceKey)
+				found = true
+			}
+		}
+
+		if !found {
+			fmt.Printf("%s not found\n", key)
+			notFound = append(notFound, key)
+		}
+	}
+
+	if len(notFound) > 0 {
+		return errors.Errorf("%s not found in the ledger", strings.Join(notFound, ", "))
+	}
+
+	return nil
+}
@@ -5,9 +5,9 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"runtime/debug"
-
-	"github.com/natenho/go-jira-migrate/migration"
+	"strings"
 )
 
 var (
@@ -38,88 +38,75 @@ func (f *flagStringArray) Set(value string) error {
 	return nil
 }
 
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(flags *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{"migrate", "[options]", "Migrate the issues selected by the query from the source to the target project", runMigrate},
+	{"mirror", "[options]", "Keep polling the source projects, migrating new issues and updating the ones already migrated", runMirror},
+	{"webhook", "[options]", "Receive the webhooks of the source instance, mirroring the issues they are about", runWebhook},
+	{"replay", "[options] FILE...", "Send recorded webhook payloads to a webhook receiver, to test it offline", runReplay},
+	{"plan", "[options]", "Print what a migration would create, without writing to the target", runPlan},
+	{"verify", "[options]", "Compare every migrated issue with its source issue, exiting with an error on differences", runVerify},
+	{"rollback", "-run ID [options]", "Delete every issue, sprint, remote link and issue link created by a run", runRollback},
+	{"report", "[-run ID] [options]", "Summarize the runs and issues recorded in the ledger", runReport},
+	{"lookup", "[options] KEY...", "Find the target issue of a source issue, or the source issue of a target issue", runLookup},
+}
+
 func main() {
-	var sourceUrl = flag.String("source", "", "Source JIRA URL (e.g. https://your-source-domain.atlassian.net/)")
-	var targetUrl = flag.String("target", "", "Target JIRA URL (e.g. https://your-target-domain.atlassian.net/)")
-	var user = flag.String("user", "", "User")
-	var apiKey = flag.String("api-key", "", "API Key (to create one, visit https://tinyurl.com/jira-api-token/)")
-	var sourceProjectKey = flag.String("source-project", "", "Source project key (e.g. MYPROJ)")
-	var targetProjectKey = flag.String("target-project", "", "Target project key (e.g. OTHER)")
-	var jql = flag.String("query", "Status != Done", "JQL query returning issues to be migrated from the selected project (e.g. \"status != Done\" to migrate only pending issues)")
-	var workers = flag.Int("workers", defaultWorkerPoolSize, "How many migrations should occur in parallel")
-	var importSprints = flag.Bool("sprints", true, "Define if sprints will be imported")
-	var deleteOnError = flag.Bool("delete-on-error", false, "Define if issues migrated with errors should be deleted")
-	var version = flag.Bool("version", false, "Print version and exit")
-
-	var customFields flagStringArray
-	flag.Var(&customFields, "field", "Custom fields to read from source project (includes 'Story point estimate' and 'Flagged' by default)")
-	customFields = append(customFields, "Story point estimate")
-	customFields = append(customFields, "Story Points")
-	customFields = append(customFields, "Flagged")
-
-	var additionalLabels flagStringArray
-	flag.Var(&additionalLabels, "label", "Additional labels to assign to migrated issues (includes 'MIGRATED' label) by default")
-	additionalLabels = append(additionalLabels, "MIGRATED")
-
-	flag.Parse()
-
-	if *version {
-		printVersion()
-		return
-	}
+	args := os.Args[1:]
 
-	if *sourceProjectKey == "" {
-		log.Println("Invalid project key")
-		printVersion()
-		flag.Usage()
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		printUsage()
 		return
 	}
 
-	if *targetProjectKey == "" {
-		log.Println("Invalid target project key")
+	if args[0] == "version" || args[0] == "-version" || args[0] == "--version" {
 		printVersion()
-		flag.Usage()
 		return
 	}
 
-	if *jql == "" {
-		log.Println("Invalid JQL query")
-		printVersion()
-		flag.Usage()
-		return
+	// Flag-only invocations predate the subcommands and keep working as an alias for migrate
+	name := "migrate"
+	if !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	migrator, err := migration.NewMigrator(
-		*sourceUrl,
-		*targetUrl,
-		*user,
-		*apiKey,
-		*sourceProjectKey,
-		*targetProjectKey,
-		migration.WithWorkerPoolSize(*workers),
-		migration.WithAdditionalLabels(additionalLabels...),
-		migration.WithCustomFields(customFields...),
-		migration.WithSprints(*importSprints),
-		migration.WithDeleteOnError(*deleteOnError),
-	)
-	if err != nil {
-		log.Println(err)
-		return
-	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		flags := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+		flags.Usage = func() {
+			fmt.Fprintf(flags.Output(), "Usage: %s %s %s\n\n%s.\n\nOptions:\n", os.Args[0], cmd.name, cmd.usage, cmd.description)
+			flags.PrintDefaults()
+		}
+
+		if err := cmd.run(flags, args); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
 
-	results, err := migrator.Execute(*jql)
-	if err != nil {
-		log.Println(err)
 		return
 	}
 
-	var issueCount int
-	for result := range results {
-		log.Println(result)
-		issueCount++
-	}
+	log.Printf("Unknown command %s", name)
+	printUsage()
+	os.Exit(2)
+}
 
-	log.Printf("%d issues processed.", issueCount)
+func printUsage() {
+	printVersion()
+	fmt.Printf("\nUsage: %s <command> [options]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Printf("  %-10s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Printf("\nRun '%s <command> -h' for the options of a command.\n", os.Args[0])
 }
 
 func printVersion() {
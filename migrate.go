@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/natenho/go-jira-migrate/migration"
+)
+
+func runMigrate(flags *flag.FlagSet, args []string) error {
+	configFlags := newConfigFlags(flags)
+	configFlags.addConnectionFlags()
+	configFlags.addProjectFlags()
+	configFlags.addMigrationFlags()
+	configFlags.Bool("delete-on-error", "Define if issues migrated with errors should be deleted", func(cfg *Config) *bool { return &cfg.DeleteOnError })
+	var resume = flags.Bool("resume", false, "Continue the last unfinished run of the same query, finishing half-migrated issues")
+	var syncUpdates = flags.Bool("sync", false, "Migrate only the issues updated since the last run of the same query without failed issues, updating the issues already migrated")
+	var rebuildLedger = flags.Bool("rebuild-ledger", false, "Rebuild the ledger from the 'Original Issue' links found in the target project and exit")
+	var output = addOutputFlags(flags)
+	var version = flags.Bool("version", false, "Print version and exit")
+
+	cfg, err := configFlags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *version {
+		printVersion()
+		return nil
+	}
+
+	if err := cfg.validateConnection(); err != nil {
+		printVersion()
+		flags.Usage()
+		return err
+	}
+
+	if err := cfg.validateMigration(); err != nil {
+		printVersion()
+		flags.Usage()
+		return err
+	}
+
+	ledger, err := migration.OpenLedger(cfg.Ledger)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+
+	transport := cfg.newTransport()
+
+	writer, err := output.open()
+	if err != nil {
+		return err
+	}
+	defer closeResultWriter(writer)
+
+	var unmappedUsers []migration.UnmappedUser
+
+	for _, project := range cfg.Projects {
+		migrator, err := newMigrator(cfg, ledger, transport, project, migration.WithResume(*resume), migration.WithSync(*syncUpdates))
+		if err != nil {
+			return err
+		}
+
+		if *rebuildLedger {
+			recovered, err := migrator.RebuildLedger()
+			log.Printf("%d issues of %s recovered into the ledger.", recovered, project.Target)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		results, err := migrator.Execute(project.query(cfg))
+		if err != nil {
+			return err
+		}
+
+		var issueCount int
+		for result := range results {
+			log.Println(result)
+			if err := writer.Write(result); err != nil {
+				log.Printf("Could not write output: %s", err)
+			}
+			issueCount++
+		}
+
+		log.Printf("%d issues processed.", issueCount)
+
+		unmappedUsers = mergeUnmappedUsers(unmappedUsers, migrator.UnmappedUsers())
+	}
+
+	if !*rebuildLedger {
+		reportUnmappedUsers(cfg, unmappedUsers)
+	}
+
+	return nil
+}
@@ -2,39 +2,85 @@ package migration
 
 import (
 	"fmt"
-	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 	"sync"
 
 	"github.com/natenho/go-jira"
+	"github.com/natenho/go-jira-migrate/internal/adf"
 	"github.com/pkg/errors"
 )
 
-func (s *migrator) migrateAttachments(sourceIssue *jira.Issue, targetIssue *jira.Issue) chan error {
+// migrateAttachments uploads the source attachments to the target issue, returning the target attachment of every
+// source attachment migrated by this run or an earlier one, by source attachment ID
+func (s *migrator) migrateAttachments(sourceIssue *jira.Issue, targetIssue *jira.Issue) (map[string]*jira.Attachment, chan error) {
 	wg := &sync.WaitGroup{}
+	mutex := &sync.Mutex{}
+	targetAttachments := map[string]*jira.Attachment{}
 
 	errChan := make(chan error, len(sourceIssue.Fields.Attachments))
 	defer close(errChan)
 
+	entry, _ := s.ledger.Get(sourceIssue.Key)
+
 	for _, item := range sourceIssue.Fields.Attachments {
+		if item == nil {
+			continue
+		}
+
+		if targetItem, ok := entry.Items[StepAttachments][item.ID]; ok {
+			targetAttachments[item.ID] = parseAttachmentItem(targetItem, item.Filename)
+			continue
+		}
+
 		wg.Add(1)
 		go func(item *jira.Attachment) {
-			errChan <- s.migrateAttachment(item, targetIssue.ID)
-			wg.Done()
+			defer wg.Done()
+
+			createdAttachment, err := s.migrateAttachment(item, targetIssue.ID)
+			if err != nil {
+				errChan <- err
+				return
+			}
+
+			mutex.Lock()
+			targetAttachments[item.ID] = createdAttachment
+			mutex.Unlock()
+
+			errChan <- s.ledger.SetItem(sourceIssue.Key, StepAttachments, item.ID, attachmentItem(createdAttachment))
 		}(item)
 	}
 
 	wg.Wait()
-	return errChan
+	return targetAttachments, errChan
+}
+
+// attachmentItem is the ledger item of a target attachment, its ID and file name as in its address, so the links
+// follow an attachment renamed by the target when a later run rewrites them
+func attachmentItem(attachment *jira.Attachment) string {
+	return attachment.ID + "/" + attachment.Filename
+}
+
+// parseAttachmentItem reads the target attachment of a ledger item, which has the source file name when recorded
+// with its ID only
+func parseAttachmentItem(item, sourceFilename string) *jira.Attachment {
+	id, filename, ok := strings.Cut(item, "/")
+	if !ok {
+		filename = sourceFilename
+	}
+
+	return &jira.Attachment{ID: id, Filename: filename}
 }
 
-func (s *migrator) migrateAttachment(attachment *jira.Attachment, targetIssueID string) (err error) {
+func (s *migrator) migrateAttachment(attachment *jira.Attachment, targetIssueID string) (*jira.Attachment, error) {
 	if attachment == nil {
-		return errors.New("Invalid attachment")
+		return nil, errors.New("Invalid attachment")
 	}
 
 	response, err := s.sourceClient.Issue.DownloadAttachment(attachment.ID)
 	if err != nil {
-		return errors.Errorf("Could not download attachment %s %s", attachment.Filename, err)
+		return nil, errors.Errorf("Could not download attachment %s %s", attachment.Filename, err)
 	}
 
 	var attachmentSize int64
@@ -43,13 +89,175 @@ func (s *migrator) migrateAttachment(attachment *jira.Attachment, targetIssueID
 		attachmentSize = response.ContentLength
 	}
 
-	_, postResponse, err := s.targetClient.Issue.PostAttachment(targetIssueID, response.Body, attachment.Filename)
+	createdAttachments, postResponse, err := s.targetClient.Issue.PostAttachment(targetIssueID, response.Body, attachment.Filename)
 	if postResponse != nil {
-		if postResponse.StatusCode == http.StatusRequestEntityTooLarge {
+		defer postResponse.Body.Close()
+	}
+
+	if err != nil {
+		err = parseResponseError(fmt.Sprintf("migrateAttachment(%s, %d bytes)", attachment.Filename, attachmentSize), postResponse, err)
+		if errors.Is(err, ErrPayloadTooLarge) {
 			err = errors.Wrapf(err, "Review upload limits for target account: Refer to https://support.atlassian.com/jira-cloud-administration/docs/configure-file-attachments/")
 		}
-		defer postResponse.Body.Close()
+		return nil, err
+	}
+
+	if createdAttachments == nil || len(*createdAttachments) == 0 {
+		return nil, errors.Errorf("migrateAttachment(%s, %d bytes): no attachment created", attachment.Filename, attachmentSize)
+	}
+
+	return &(*createdAttachments)[0], nil
+}
+
+// rewriteAttachmentReferences points the images and attachment links of the target description and of the migrated
+// comments to the target attachments, as links to source attachments are broken on the target
+func (s *migrator) rewriteAttachmentReferences(sourceIssue *jira.Issue, targetIssue *jira.Issue, targetAttachments map[string]*jira.Attachment) error {
+	if len(targetAttachments) == 0 {
+		return nil
+	}
+
+	if s.adf {
+		return s.rewriteAttachmentDocumentReferences(sourceIssue, targetIssue, targetAttachments)
+	}
+
+	currentIssue, err := s.getTargetIssueByKey(targetIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	if description := s.rewriteAttachmentLinks(currentIssue.Fields.Description, sourceIssue, targetAttachments); description != currentIssue.Fields.Description {
+		response, err := s.targetClient.Issue.UpdateIssue(currentIssue.Key, map[string]interface{}{"fields": map[string]interface{}{"description": description}})
+		if err != nil {
+			return parseResponseError("UpdateIssue", response, err)
+		}
+		response.Body.Close()
+	}
+
+	comments, err := getComments(s.targetClient, currentIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	migratedComments := s.getMigratedComments(sourceIssue)
+
+	for _, comment := range comments {
+		if !migratedComments[comment.ID] {
+			continue
+		}
+
+		body := s.rewriteAttachmentLinks(comment.Body, sourceIssue, targetAttachments)
+		if body == comment.Body {
+			continue
+		}
+
+		if _, response, err := s.targetClient.Issue.UpdateComment(currentIssue.ID, &jira.Comment{ID: comment.ID, Body: body}); err != nil {
+			return parseResponseError("UpdateComment", response, err)
+		}
+	}
+
+	return nil
+}
+
+// rewriteAttachmentDocumentReferences is the document counterpart of rewriteAttachmentReferences, also pointing
+// the embedded media to the target attachments
+func (s *migrator) rewriteAttachmentDocumentReferences(sourceIssue *jira.Issue, targetIssue *jira.Issue, targetAttachments map[string]*jira.Attachment) error {
+	rewrite := func(document *adf.Node) bool {
+		linksChanged := rewriteDocumentText(document, func(text string) string {
+			return s.rewriteAttachmentLinks(text, sourceIssue, targetAttachments)
+		})
+		return s.rewriteMediaNodes(document, sourceIssue, targetAttachments) || linksChanged
+	}
+
+	description, err := getDescriptionDocument(s.targetClient, targetIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	if description != nil && rewrite(description) {
+		if err := setDescriptionDocument(s.targetClient, targetIssue.Key, description); err != nil {
+			return err
+		}
+	}
+
+	comments, err := getCommentDocuments(s.targetClient, targetIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	migratedComments := s.getMigratedComments(sourceIssue)
+
+	for _, comment := range comments {
+		if !migratedComments[comment.ID] || comment.Body == nil || !rewrite(comment.Body) {
+			continue
+		}
+
+		if err := updateCommentDocument(s.targetClient, targetIssue.ID, comment.ID, comment.Body); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getMigratedComments returns the IDs of the target comments migrated from the source issue
+func (s *migrator) getMigratedComments(sourceIssue *jira.Issue) map[string]bool {
+	entry, _ := s.ledger.Get(sourceIssue.Key)
+	migratedComments := map[string]bool{}
+	for _, targetCommentID := range entry.Items[StepComments] {
+		migratedComments[targetCommentID] = true
+	}
+
+	return migratedComments
+}
+
+// attachmentURLPattern matches the links to attachments of the source instance, absolute or relative to it
+func (s *migrator) attachmentURLPattern() *regexp.Regexp {
+	s.attachmentPatternOnce.Do(func() {
+		s.attachmentPattern = regexp.MustCompile(`(` + regexp.QuoteMeta(siteURL(s.sourceClient)) + `|^|[\s|!\[(])/(?:secure/attachment|rest/api/[23]/attachment/content)/([0-9]+)(/[^\s|!\])]*)?`)
+	})
+
+	return s.attachmentPattern
+}
+
+// rewriteAttachmentLinks replaces the links to source attachments by links to the target ones, and the images and
+// attachments embedded by file name when the target attachment was given another name
+func (s *migrator) rewriteAttachmentLinks(text string, sourceIssue *jira.Issue, targetAttachments map[string]*jira.Attachment) string {
+	sourceURL := siteURL(s.sourceClient)
+	targetURL := siteURL(s.targetClient)
+
+	pattern := s.attachmentURLPattern()
+	text = pattern.ReplaceAllStringFunc(text, func(link string) string {
+		match := pattern.FindStringSubmatch(link)
+
+		targetAttachment, ok := targetAttachments[match[2]]
+		if !ok {
+			return link
+		}
+
+		prefix := match[1]
+		if prefix == sourceURL {
+			prefix = ""
+		}
+
+		return fmt.Sprintf("%s%s/secure/attachment/%s/%s", prefix, targetURL, targetAttachment.ID, url.PathEscape(targetAttachment.Filename))
+	})
+
+	for _, sourceAttachment := range sourceIssue.Fields.Attachments {
+		if sourceAttachment == nil {
+			continue
+		}
+
+		targetAttachment, ok := targetAttachments[sourceAttachment.ID]
+		if !ok || targetAttachment.Filename == sourceAttachment.Filename {
+			continue
+		}
+
+		text = strings.NewReplacer(
+			"!"+sourceAttachment.Filename+"!", "!"+targetAttachment.Filename+"!",
+			"!"+sourceAttachment.Filename+"|", "!"+targetAttachment.Filename+"|",
+			"[^"+sourceAttachment.Filename+"]", "[^"+targetAttachment.Filename+"]",
+		).Replace(text)
 	}
 
-	return parseResponseError(fmt.Sprintf("migrateAttachment(%s, %d bytes)", attachment.Filename, attachmentSize), postResponse, err)
+	return text
 }
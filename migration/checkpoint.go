@@ -0,0 +1,66 @@
+package migration
+
+import "sync"
+
+// checkpoint tracks which search pages were fully migrated, so an interrupted run can resume from the first unfinished page
+type checkpoint struct {
+	mutex  sync.Mutex
+	ledger *Ledger
+	runID  string
+	pages  []*checkpointPage
+}
+
+type checkpointPage struct {
+	startAt int
+	next    int
+	pending int
+}
+
+type pendingIssue struct {
+	key  string
+	page *checkpointPage
+}
+
+func newCheckpoint(ledger *Ledger, runID string) *checkpoint {
+	return &checkpoint{ledger: ledger, runID: runID}
+}
+
+func (c *checkpoint) addPage(startAt int, issueCount int) *checkpointPage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	page := &checkpointPage{startAt: startAt, next: startAt + issueCount, pending: issueCount}
+	c.pages = append(c.pages, page)
+
+	return page
+}
+
+// done marks an issue of the page as finished and moves the checkpoint past every finished page. Failed issues are
+// recorded on the run first, so a resumed run retries them even though their page is behind the checkpoint.
+// Issues retried from an earlier attempt have no page.
+func (c *checkpoint) done(page *checkpointPage, sourceKey string, failed bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.ledger.SetRunIssueFailed(c.runID, sourceKey, failed); err != nil {
+		return err
+	}
+
+	if page == nil {
+		return nil
+	}
+
+	page.pending--
+
+	var finishedPage *checkpointPage
+	for len(c.pages) > 0 && c.pages[0].pending <= 0 {
+		finishedPage = c.pages[0]
+		c.pages = c.pages[1:]
+	}
+
+	if finishedPage == nil {
+		return nil
+	}
+
+	return c.ledger.SetRunCheckpoint(c.runID, finishedPage.next)
+}
@@ -2,29 +2,199 @@ package migration
 
 import (
 	"fmt"
-	"sync"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
 
 	"github.com/natenho/go-jira"
+	"github.com/natenho/go-jira-migrate/internal/adf"
+	"github.com/pkg/errors"
 )
 
-func (s *migrator) migrateComments(sourceIssue *jira.Issue, targetIssue *jira.Issue) chan error {
-	wg := &sync.WaitGroup{}
+// CommentResult is the outcome of the migration of a source comment
+type CommentResult struct {
+	SourceID string `json:"sourceId"`
+	TargetID string `json:"targetId,omitempty"`
+	// Status is done, skipped when an earlier run migrated the comment, or failed
+	Status string `json:"status"`
+	// Visibility is the role or group the target comment is restricted to, empty when visible to everyone
+	Visibility string `json:"visibility,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// WithVisibilityMapping restricts the comments visible to a source role or group to another target role or group
+func WithVisibilityMapping(sourceName, targetName string) Option {
+	return func(m *migrator) {
+		m.visibilityMappings[sourceName] = targetName
+	}
+}
+
+type commentPage struct {
+	StartAt    int             `json:"startAt"`
+	MaxResults int             `json:"maxResults"`
+	Total      int             `json:"total"`
+	Comments   []*jira.Comment `json:"comments"`
+}
+
+// getComments reads every comment of an issue, oldest first, as the comments of the issue itself may be truncated
+func getComments(client *jira.Client, issueKey string) ([]*jira.Comment, error) {
+	var comments []*jira.Comment
+
+	for {
+		request, err := client.NewRequest(http.MethodGet, fmt.Sprintf("rest/api/2/issue/%s/comment?orderBy=created&startAt=%d&maxResults=%d", url.PathEscape(issueKey), len(comments), maxResultsPerSearch), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		page := &commentPage{}
+		response, err := client.Do(request, page)
+		if err != nil {
+			return nil, parseResponseError("GetComments", response, err)
+		}
+		response.Body.Close()
+
+		comments = append(comments, page.Comments...)
+
+		if len(page.Comments) == 0 || len(comments) >= page.Total {
+			break
+		}
+	}
+
+	// Older Server versions ignore orderBy
+	sort.SliceStable(comments, func(i, j int) bool {
+		return commentTime(comments[i]).Before(commentTime(comments[j]))
+	})
+
+	return comments, nil
+}
+
+func commentTime(comment *jira.Comment) time.Time {
+	created, _ := time.Parse(jiraTimeLayout, comment.Created)
+	return created
+}
+
+// migrateComments adds the source comments to the target issue one by one in chronological order, skipping the ones
+// migrated by earlier runs. The source issue comments are replaced by the complete list.
+func (s *migrator) migrateComments(sourceIssue *jira.Issue, targetIssue *jira.Issue) ([]CommentResult, chan error) {
+	comments, err := getComments(s.sourceClient, sourceIssue.Key)
+	if err != nil {
+		errChan := make(chan error, 1)
+		errChan <- err
+		close(errChan)
+		return nil, errChan
+	}
 
-	errChan := make(chan error, len(sourceIssue.Fields.Attachments))
+	sourceIssue.Fields.Comments = &jira.Comments{Comments: comments}
+
+	errChan := make(chan error, len(comments)+1)
 	defer close(errChan)
 
-	for _, item := range sourceIssue.Fields.Comments.Comments {
-		item.Body = fmt.Sprintf("_On %s [~accountid:%s] wrote:_\n\n%s", item.Created, item.Author.AccountID, item.Body)
-		_, response, err := s.targetClient.Issue.AddComment(targetIssue.ID, item)
+	sourceDocuments, err := s.getSourceCommentDocuments(sourceIssue)
+	if err != nil {
+		errChan <- err
+		return nil, errChan
+	}
+
+	entry, _ := s.ledger.Get(sourceIssue.Key)
+	results := make([]CommentResult, 0, len(comments))
+
+	for _, item := range comments {
+		result := CommentResult{SourceID: item.ID, Status: StepStatusDone}
+		if targetVisibility := s.mapVisibility(item.Visibility); targetVisibility != nil {
+			result.Visibility = targetVisibility.Type + ":" + targetVisibility.Value
+		}
+
+		if targetCommentID, ok := entry.Items[StepComments][item.ID]; ok {
+			result.TargetID = targetCommentID
+			result.Status = StepStatusSkipped
+			results = append(results, result)
+			continue
+		}
+
+		targetCommentID, err := s.migrateComment(item, sourceDocuments, targetIssue)
+		if err == nil {
+			result.TargetID = targetCommentID
+			err = s.ledger.SetItem(sourceIssue.Key, StepComments, item.ID, targetCommentID)
+		}
+
+		if err == nil && s.rewriteReferences {
+			err = s.ledger.SetItem(sourceIssue.Key, StepReferences, targetCommentID, s.runID)
+		}
+
+		if err != nil {
+			err = errors.Wrapf(err, "comment %s", item.ID)
+			result.Status = StepStatusFailed
+			result.Error = err.Error()
+			errChan <- err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, errChan
+}
+
+// migrateComment adds the source comment to the target issue, as a document when its source document was read
+func (s *migrator) migrateComment(item *jira.Comment, sourceDocuments map[string]*adf.Node, targetIssue *jira.Issue) (string, error) {
+	targetVisibility := s.mapVisibility(item.Visibility)
+
+	if document, ok := sourceDocuments[item.ID]; ok {
+		createdComment, err := addCommentDocument(s.targetClient, targetIssue.ID, &commentDocument{
+			Body:       s.buildCommentDocument(item, document),
+			Visibility: targetVisibility,
+		})
 		if err != nil {
-			wg.Add(1)
-			go func() {
-				errChan <- parseResponseError("AddComment", response, err)
-				wg.Done()
-			}()
+			return "", err
 		}
+
+		return createdComment.ID, nil
+	}
+
+	comment := &jira.Comment{
+		Body: fmt.Sprintf("_On %s %s wrote:_\n\n%s", item.Created, s.mention(&item.Author), s.rewriteContent(item.Body)),
+	}
+	if targetVisibility != nil {
+		comment.Visibility = *targetVisibility
+	}
+
+	createdComment, response, err := s.targetClient.Issue.AddComment(targetIssue.ID, comment)
+	if err != nil {
+		return "", parseResponseError("AddComment", response, err)
+	}
+
+	return createdComment.ID, nil
+}
+
+// getSourceCommentDocuments reads the bodies of the source comments as documents, by comment ID, when documents are migrated
+func (s *migrator) getSourceCommentDocuments(sourceIssue *jira.Issue) (map[string]*adf.Node, error) {
+	documents := map[string]*adf.Node{}
+	if !s.adf || len(sourceIssue.Fields.Comments.Comments) == 0 {
+		return documents, nil
+	}
+
+	comments, err := getCommentDocuments(s.sourceClient, sourceIssue.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, comment := range comments {
+		documents[comment.ID] = comment.Body
+	}
+
+	return documents, nil
+}
+
+// mapVisibility returns the target role or group a source comment is restricted to, or nil when it is visible to everyone
+func (s *migrator) mapVisibility(sourceVisibility jira.CommentVisibility) *jira.CommentVisibility {
+	if sourceVisibility.Value == "" {
+		return nil
+	}
+
+	targetVisibility := sourceVisibility
+	if targetName, ok := s.visibilityMappings[sourceVisibility.Value]; ok {
+		targetVisibility.Value = targetName
 	}
 
-	wg.Wait()
-	return errChan
+	return &targetVisibility
 }
@@ -0,0 +1,182 @@
+package migration
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	adaptiveInterval = 15 * time.Second
+	// Latency this much above the baseline means the instance is struggling
+	adaptiveLatencyTolerance = 1.5
+	// Weight of the latest interval in the baseline latency, so the baseline follows the instance instead of
+	// sticking to its fastest interval
+	adaptiveLatencyWeight = 0.25
+	// Throughput must grow this much for another worker to be worth it
+	adaptiveThroughputGain = 1.05
+)
+
+// concurrencyLimiter limits how many workers migrate issues at the same time
+type concurrencyLimiter struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+}
+
+func newConcurrencyLimiter(limit int) *concurrencyLimiter {
+	l := &concurrencyLimiter{limit: limit}
+	l.cond = sync.NewCond(&l.mutex)
+	return l
+}
+
+// acquire waits until the worker is allowed to migrate an issue
+func (l *concurrencyLimiter) acquire() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+
+	l.active++
+}
+
+func (l *concurrencyLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.active--
+	l.cond.Broadcast()
+}
+
+func (l *concurrencyLimiter) Limit() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.limit
+}
+
+// setLimit changes the limit, workers over it stop once their current issue is migrated
+func (l *concurrencyLimiter) setLimit(limit int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.limit = limit
+	l.cond.Broadcast()
+}
+
+// concurrencyController adds workers while the throughput improves, and removes them when requests are throttled or get slower
+type concurrencyController struct {
+	// completed issues since the last adjustment, first to be 64-bit aligned
+	completed int64
+
+	limiter    *concurrencyLimiter
+	transport  *Transport
+	minWorkers int
+	maxWorkers int
+
+	lastThroughput float64
+	// baselineLatency is the moving average of the request latency, reset when workers are removed
+	baselineLatency time.Duration
+	stop            chan struct{}
+}
+
+func newConcurrencyController(transport *Transport, minWorkers, maxWorkers int) *concurrencyController {
+	return &concurrencyController{
+		limiter:    newConcurrencyLimiter(minWorkers),
+		transport:  transport,
+		minWorkers: minWorkers,
+		maxWorkers: maxWorkers,
+		stop:       make(chan struct{}),
+	}
+}
+
+func (c *concurrencyController) done() {
+	atomic.AddInt64(&c.completed, 1)
+}
+
+// run adjusts the concurrency on every interval until stopped
+func (c *concurrencyController) run() {
+	log.Printf("Adaptive concurrency starting with %d workers, between %d and %d", c.minWorkers, c.minWorkers, c.maxWorkers)
+
+	ticker := time.NewTicker(adaptiveInterval)
+	defer ticker.Stop()
+
+	c.transport.stats()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.adjust(adaptiveInterval)
+		}
+	}
+}
+
+func (c *concurrencyController) adjust(interval time.Duration) {
+	completed := atomic.SwapInt64(&c.completed, 0)
+	requests, latency, throttled := c.transport.stats()
+
+	throughput := float64(completed) / interval.Seconds()
+
+	var averageLatency time.Duration
+	if requests > 0 {
+		averageLatency = latency / time.Duration(requests)
+	}
+
+	limit := c.limiter.Limit()
+	newLimit := limit
+	var reason string
+
+	// More latency is fine while the throughput still grows with the workers
+	switch {
+	case throttled > 0:
+		newLimit = limit * 3 / 4
+		reason = fmt.Sprintf("%d requests throttled", throttled)
+	case completed > 0 && throughput > c.lastThroughput*adaptiveThroughputGain:
+		newLimit = limit + 1
+		reason = fmt.Sprintf("throughput %.2f issues/s, up from %.2f", throughput, c.lastThroughput)
+	case c.baselineLatency > 0 && float64(averageLatency) > float64(c.baselineLatency)*adaptiveLatencyTolerance:
+		newLimit = limit - 1
+		reason = fmt.Sprintf("request latency %s, up from %s", averageLatency.Round(time.Millisecond), c.baselineLatency.Round(time.Millisecond))
+	case completed == 0:
+		reason = "no issue finished in the last interval"
+	default:
+		reason = fmt.Sprintf("throughput %.2f issues/s, not improving on %.2f", throughput, c.lastThroughput)
+	}
+
+	if newLimit < c.minWorkers {
+		newLimit = c.minWorkers
+	}
+
+	if newLimit > c.maxWorkers {
+		newLimit = c.maxWorkers
+	}
+
+	// Once workers are removed, the latency is compared to the one of the smaller pool
+	switch {
+	case newLimit < limit:
+		c.baselineLatency = 0
+	case averageLatency > 0 && c.baselineLatency == 0:
+		c.baselineLatency = averageLatency
+	case averageLatency > 0:
+		c.baselineLatency += time.Duration(float64(averageLatency-c.baselineLatency) * adaptiveLatencyWeight)
+	}
+
+	if completed > 0 {
+		c.lastThroughput = throughput
+	}
+
+	if newLimit == limit {
+		log.Printf("Adaptive concurrency: keeping %d workers, %s", limit, reason)
+	} else {
+		log.Printf("Adaptive concurrency: %d -> %d workers, %s", limit, newLimit, reason)
+	}
+
+	c.limiter.setLimit(newLimit)
+}
@@ -0,0 +1,124 @@
+package migration
+
+import (
+	"testing"
+	"time"
+)
+
+type adjustInterval struct {
+	completed int64
+	requests  int64
+	latency   time.Duration
+	throttled int64
+	// limit is the number of workers expected after the interval
+	limit int
+}
+
+func TestConcurrencyControllerAdjust(t *testing.T) {
+	tests := []struct {
+		name       string
+		minWorkers int
+		maxWorkers int
+		intervals  []adjustInterval
+	}{
+		{
+			name:       "adds workers while the throughput grows",
+			minWorkers: 2,
+			maxWorkers: 4,
+			intervals: []adjustInterval{
+				{completed: 10, requests: 100, latency: 100 * time.Millisecond, limit: 3},
+				{completed: 20, requests: 100, latency: 100 * time.Millisecond, limit: 4},
+				{completed: 30, requests: 100, latency: 100 * time.Millisecond, limit: 4},
+			},
+		},
+		{
+			name:       "keeps the workers when the throughput stalls",
+			minWorkers: 2,
+			maxWorkers: 8,
+			intervals: []adjustInterval{
+				{completed: 10, requests: 100, latency: 100 * time.Millisecond, limit: 3},
+				{completed: 10, requests: 100, latency: 100 * time.Millisecond, limit: 3},
+				{requests: 100, latency: 100 * time.Millisecond, limit: 3},
+			},
+		},
+		{
+			name:       "removes a quarter of the workers when throttled",
+			minWorkers: 2,
+			maxWorkers: 16,
+			intervals: []adjustInterval{
+				{completed: 10, requests: 100, latency: 100 * time.Millisecond, limit: 3},
+				{completed: 20, requests: 100, latency: 100 * time.Millisecond, limit: 4},
+				{completed: 30, requests: 100, latency: 100 * time.Millisecond, limit: 5},
+				{completed: 40, requests: 100, latency: 100 * time.Millisecond, limit: 6},
+				{completed: 40, requests: 100, latency: 100 * time.Millisecond, throttled: 3, limit: 4},
+				{completed: 40, requests: 100, latency: 100 * time.Millisecond, throttled: 3, limit: 3},
+				{completed: 40, requests: 100, latency: 100 * time.Millisecond, throttled: 3, limit: 2},
+				{completed: 40, requests: 100, latency: 100 * time.Millisecond, throttled: 3, limit: 2},
+			},
+		},
+		{
+			name:       "one fast interval does not shrink the pool",
+			minWorkers: 2,
+			maxWorkers: 8,
+			intervals: []adjustInterval{
+				{completed: 10, requests: 100, latency: 200 * time.Millisecond, limit: 3},
+				{completed: 20, requests: 100, latency: 20 * time.Millisecond, limit: 4},
+				{completed: 20, requests: 100, latency: 200 * time.Millisecond, limit: 4},
+				{completed: 20, requests: 100, latency: 200 * time.Millisecond, limit: 4},
+			},
+		},
+		{
+			name:       "removes one worker when the latency rises without more throughput",
+			minWorkers: 2,
+			maxWorkers: 8,
+			intervals: []adjustInterval{
+				{completed: 10, requests: 100, latency: 100 * time.Millisecond, limit: 3},
+				{completed: 20, requests: 100, latency: 100 * time.Millisecond, limit: 4},
+				{completed: 20, requests: 100, latency: 300 * time.Millisecond, limit: 3},
+				// The baseline is reset after the decrease, so the slower latency is not held against the smaller pool
+				{completed: 20, requests: 100, latency: 300 * time.Millisecond, limit: 3},
+				{completed: 20, requests: 100, latency: 300 * time.Millisecond, limit: 3},
+			},
+		},
+		{
+			name:       "more latency is fine while the throughput grows",
+			minWorkers: 2,
+			maxWorkers: 8,
+			intervals: []adjustInterval{
+				{completed: 10, requests: 100, latency: 100 * time.Millisecond, limit: 3},
+				{completed: 20, requests: 100, latency: 300 * time.Millisecond, limit: 4},
+			},
+		},
+		{
+			name:       "the baseline follows a lasting latency change",
+			minWorkers: 2,
+			maxWorkers: 8,
+			intervals: []adjustInterval{
+				{completed: 10, requests: 100, latency: 100 * time.Millisecond, limit: 3},
+				{completed: 10, requests: 100, latency: 130 * time.Millisecond, limit: 3},
+				{completed: 10, requests: 100, latency: 155 * time.Millisecond, limit: 3},
+				{completed: 10, requests: 100, latency: 175 * time.Millisecond, limit: 3},
+				{completed: 10, requests: 100, latency: 195 * time.Millisecond, limit: 3},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := newConcurrencyController(NewTransport(0, 0), test.minWorkers, test.maxWorkers)
+
+			for i, interval := range test.intervals {
+				controller.completed = interval.completed
+				controller.transport.requests = interval.requests
+				controller.transport.latency = int64(interval.latency) * interval.requests
+				controller.transport.throttled = interval.throttled
+
+				controller.adjust(time.Second)
+
+				if limit := controller.limiter.Limit(); limit != interval.limit {
+					t.Fatalf("interval %d: got %d workers, want %d", i, limit, interval.limit)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package migration
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/natenho/go-jira"
+	"github.com/pkg/errors"
+)
+
+const (
+	// AuthBasic authenticates with a user and an API token (Cloud) or password (Server/Data Center)
+	AuthBasic = "basic"
+	// AuthBearer authenticates with a personal access token (Server/Data Center)
+	AuthBearer = "bearer"
+)
+
+// Connection is where and how to connect to a Jira instance
+type Connection struct {
+	URL      string
+	AuthType string
+	User     string
+	Token    string
+}
+
+// ConnectionProblem is a missing or invalid setting of a connection
+type ConnectionProblem struct {
+	// Setting is url, auth, user or apiKey
+	Setting string
+	Message string
+}
+
+func (p ConnectionProblem) String() string {
+	return p.Setting + ": " + p.Message
+}
+
+// Validate returns the problems preventing the connection from being used, none when it is valid
+func (c Connection) Validate() []ConnectionProblem {
+	var problems []ConnectionProblem
+
+	if c.URL == "" {
+		problems = append(problems, ConnectionProblem{Setting: "url", Message: "is required"})
+	} else if _, err := url.Parse(c.URL); err != nil {
+		problems = append(problems, ConnectionProblem{Setting: "url", Message: fmt.Sprintf("is not a valid URL, found %q", c.URL)})
+	}
+
+	switch c.AuthType {
+	case "", AuthBasic:
+		if c.User == "" {
+			problems = append(problems, ConnectionProblem{Setting: "user", Message: "is required with basic auth"})
+		}
+	case AuthBearer:
+	default:
+		problems = append(problems, ConnectionProblem{Setting: "auth", Message: fmt.Sprintf("must be %q or %q, found %q", AuthBasic, AuthBearer, c.AuthType)})
+	}
+
+	if c.Token == "" {
+		problems = append(problems, ConnectionProblem{Setting: "apiKey", Message: "is required"})
+	}
+
+	return problems
+}
+
+// validate checks the connection of the side (source or target) of the migration
+func (c Connection) validate(side string) error {
+	if problems := c.Validate(); len(problems) > 0 {
+		return errors.Errorf("invalid %s connection, %s", side, problems[0])
+	}
+
+	return nil
+}
+
+// newClient creates a client authenticating the requests sent through the transport
+func (c Connection) newClient(transport http.RoundTripper) (*jira.Client, error) {
+	var httpClient *http.Client
+
+	if c.AuthType == AuthBearer {
+		httpClient = (&jira.BearerAuthTransport{Token: c.Token, Transport: transport}).Client()
+	} else {
+		httpClient = (&jira.BasicAuthTransport{Username: c.User, Password: c.Token, Transport: transport}).Client()
+	}
+
+	return jira.NewClient(httpClient, c.URL)
+}
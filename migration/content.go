@@ -0,0 +1,49 @@
+package migration
+
+import (
+	"regexp"
+
+	"github.com/natenho/go-jira"
+)
+
+// mentionPattern matches the wiki markup mentions, [~accountid:ID] on Cloud and [~username] on Server
+var mentionPattern = regexp.MustCompile(`\[~(accountid:)?([^\]\s]+)\]`)
+
+// rewriteContent rewrites the wiki markup of a source description or comment body so it makes sense on the target
+func (s *migrator) rewriteContent(text string) string {
+	return s.rewriteMentions(text)
+}
+
+// rewriteMentions replaces the mentions of source users by mentions of their target users,
+// or by their display names when they have no target user
+func (s *migrator) rewriteMentions(text string) string {
+	return mentionPattern.ReplaceAllStringFunc(text, func(mention string) string {
+		match := mentionPattern.FindStringSubmatch(mention)
+		return s.mention(s.getSourceUser(match[2], match[1] != ""))
+	})
+}
+
+// getSourceUser reads a mentioned source user, or returns a reference to it when it cannot be read
+func (s *migrator) getSourceUser(id string, isAccountID bool) *jira.User {
+	s.users.mutex.Lock()
+	sourceUser, ok := s.users.sourceUsers[id]
+	s.users.mutex.Unlock()
+
+	if ok {
+		return sourceUser
+	}
+
+	sourceUser, err := s.sourceInstance.getUser(s.sourceClient, id)
+	if err != nil || sourceUser == nil {
+		sourceUser = &jira.User{Name: id, DisplayName: id}
+		if isAccountID {
+			sourceUser = &jira.User{AccountID: id, DisplayName: id}
+		}
+	}
+
+	s.users.mutex.Lock()
+	s.users.sourceUsers[id] = sourceUser
+	s.users.mutex.Unlock()
+
+	return sourceUser
+}
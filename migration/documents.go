@@ -0,0 +1,337 @@
+package migration
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/natenho/go-jira"
+	"github.com/natenho/go-jira-migrate/internal/adf"
+)
+
+// footerColor is the color of the paragraphs added to the migrated descriptions
+const footerColor = "#ff5630"
+
+// WithADF defines if descriptions and comments are migrated as Atlassian Document Format through the REST API v3,
+// keeping the tables, panels, mentions and media lost when converted to wiki markup. Only Cloud instances support it.
+func WithADF(value bool) Option {
+	return func(m *migrator) {
+		m.adf = value
+	}
+}
+
+// commentDocument is a comment of the REST API v3, whose body is a document
+type commentDocument struct {
+	ID         string                  `json:"id,omitempty"`
+	Body       *adf.Node               `json:"body"`
+	Created    string                  `json:"created,omitempty"`
+	Visibility *jira.CommentVisibility `json:"visibility,omitempty"`
+}
+
+type commentDocumentPage struct {
+	StartAt    int                `json:"startAt"`
+	MaxResults int                `json:"maxResults"`
+	Total      int                `json:"total"`
+	Comments   []*commentDocument `json:"comments"`
+}
+
+// getDescriptionDocument reads the description of an issue as a document, which is nil when the issue has no description
+func getDescriptionDocument(client *jira.Client, issueKey string) (*adf.Node, error) {
+	request, err := client.NewRequest(http.MethodGet, fmt.Sprintf("rest/api/3/issue/%s?fields=description", url.PathEscape(issueKey)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	issue := &struct {
+		Fields struct {
+			Description *adf.Node `json:"description"`
+		} `json:"fields"`
+	}{}
+
+	response, err := client.Do(request, issue)
+	if err != nil {
+		return nil, parseResponseError("GetDescription", response, err)
+	}
+	defer response.Body.Close()
+
+	return issue.Fields.Description, nil
+}
+
+func setDescriptionDocument(client *jira.Client, issueKey string, document *adf.Node) error {
+	body := map[string]interface{}{"fields": map[string]interface{}{"description": document}}
+
+	request, err := client.NewRequest(http.MethodPut, fmt.Sprintf("rest/api/3/issue/%s", url.PathEscape(issueKey)), body)
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Do(request, nil)
+	if err != nil {
+		return parseResponseError("SetDescription", response, err)
+	}
+	defer response.Body.Close()
+
+	return nil
+}
+
+// getCommentDocuments reads every comment of an issue, oldest first
+func getCommentDocuments(client *jira.Client, issueKey string) ([]*commentDocument, error) {
+	var comments []*commentDocument
+
+	for {
+		request, err := client.NewRequest(http.MethodGet, fmt.Sprintf("rest/api/3/issue/%s/comment?orderBy=created&startAt=%d&maxResults=%d", url.PathEscape(issueKey), len(comments), maxResultsPerSearch), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		page := &commentDocumentPage{}
+		response, err := client.Do(request, page)
+		if err != nil {
+			return nil, parseResponseError("GetComments", response, err)
+		}
+		response.Body.Close()
+
+		comments = append(comments, page.Comments...)
+
+		if len(page.Comments) == 0 || len(comments) >= page.Total {
+			return comments, nil
+		}
+	}
+}
+
+func addCommentDocument(client *jira.Client, issueID string, comment *commentDocument) (*commentDocument, error) {
+	request, err := client.NewRequest(http.MethodPost, fmt.Sprintf("rest/api/3/issue/%s/comment", url.PathEscape(issueID)), comment)
+	if err != nil {
+		return nil, err
+	}
+
+	createdComment := &commentDocument{}
+	response, err := client.Do(request, createdComment)
+	if err != nil {
+		return nil, parseResponseError("AddComment", response, err)
+	}
+	defer response.Body.Close()
+
+	return createdComment, nil
+}
+
+func updateCommentDocument(client *jira.Client, issueID, commentID string, body *adf.Node) error {
+	request, err := client.NewRequest(http.MethodPut, fmt.Sprintf("rest/api/3/issue/%s/comment/%s", url.PathEscape(issueID), url.PathEscape(commentID)), map[string]interface{}{"body": body})
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Do(request, nil)
+	if err != nil {
+		return parseResponseError("UpdateComment", response, err)
+	}
+	defer response.Body.Close()
+
+	return nil
+}
+
+// migrateDescription sets the description document of the target issue, which was created from the wiki markup
+func (s *migrator) migrateDescription(sourceIssue, targetIssue *jira.Issue) error {
+	if !s.adf {
+		return nil
+	}
+
+	// The issue returned by the creation has no fields, the assignee is read to choose the footers
+	currentIssue, err := s.getTargetIssueByKey(targetIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	return s.migrateDescriptionDocument(sourceIssue, currentIssue, targetIssue.Key)
+}
+
+// migrateDescriptionDocument replaces the description of the target issue, created from the wiki markup, by the
+// source document followed by the migration footers
+func (s *migrator) migrateDescriptionDocument(sourceIssue, targetIssue *jira.Issue, targetKey string) error {
+	document, err := getDescriptionDocument(s.sourceClient, sourceIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	return setDescriptionDocument(s.targetClient, targetKey, s.buildDescriptionDocument(document, sourceIssue, targetIssue))
+}
+
+// buildDescriptionDocument is the document counterpart of the description built by buildTargetIssue
+func (s *migrator) buildDescriptionDocument(document *adf.Node, sourceIssue, targetIssue *jira.Issue) *adf.Node {
+	if document == nil {
+		document = adf.Doc()
+	}
+
+	s.rewriteDocument(document)
+
+	if s.isReassignedToCurrentUser(sourceIssue, targetIssue) {
+		document.Append(adf.Paragraph(
+			adf.Text("Original issue assigned to ", adf.Em(), adf.TextColor(footerColor)),
+			s.mentionNode(sourceIssue.Fields.Assignee)))
+	}
+
+	created := time.Time(sourceIssue.Fields.Created)
+	document.Append(adf.Paragraph(
+		adf.Text("Original issue ", adf.Em(), adf.TextColor(footerColor)),
+		adf.Text(sourceIssue.Key, adf.Em(), adf.TextColor(footerColor), adf.Link(s.getSourceUrl(sourceIssue))),
+		adf.Text(fmt.Sprintf(" created on %s by ", created), adf.Em(), adf.TextColor(footerColor)),
+		s.mentionNode(sourceIssue.Fields.Reporter)))
+
+	return document
+}
+
+// isReassignedToCurrentUser tells whether the target issue is assigned to the migration user instead of the target
+// user of the source assignee
+func (s *migrator) isReassignedToCurrentUser(sourceIssue, targetIssue *jira.Issue) bool {
+	sourceAssignee, targetAssignee := sourceIssue.Fields.Assignee, targetIssue.Fields.Assignee
+	if sourceAssignee == nil || targetAssignee == nil || s.currentUser == nil || userID(targetAssignee) != userID(s.currentUser) {
+		return false
+	}
+
+	mappedAssignee := s.mapUser(sourceAssignee)
+	return mappedAssignee == nil || userID(mappedAssignee) != userID(s.currentUser)
+}
+
+// buildCommentDocument is the document counterpart of the comment body built by migrateComments
+func (s *migrator) buildCommentDocument(comment *jira.Comment, body *adf.Node) *adf.Node {
+	if body == nil {
+		body = adf.Doc()
+	}
+
+	s.rewriteDocument(body)
+
+	body.Prepend(adf.Paragraph(
+		adf.Text(fmt.Sprintf("On %s ", comment.Created), adf.Em()),
+		s.mentionNode(&comment.Author),
+		adf.Text(" wrote:", adf.Em())))
+
+	return body
+}
+
+// rewriteDocument is the document counterpart of rewriteContent
+func (s *migrator) rewriteDocument(document *adf.Node) {
+	document.Transform(func(node *adf.Node) []*adf.Node {
+		if node.Type != adf.TypeMention {
+			return []*adf.Node{node}
+		}
+
+		return []*adf.Node{s.mentionNode(s.getSourceUser(node.Attr("id"), true))}
+	})
+}
+
+// mentionNode is the document counterpart of mention
+func (s *migrator) mentionNode(sourceUser *jira.User) *adf.Node {
+	if sourceUser == nil {
+		return adf.Text("Anonymous")
+	}
+
+	name := sourceUser.DisplayName
+	if name == "" {
+		name = userID(sourceUser)
+	}
+
+	targetUser := s.mapUser(sourceUser)
+	if targetUser == nil {
+		return adf.Text(name)
+	}
+
+	return adf.Mention(targetUser.AccountID, "@"+name)
+}
+
+// rewriteDocumentText replaces the texts, link addresses and card addresses of a document by the result of rewrite,
+// returning whether any of them changed
+func rewriteDocumentText(document *adf.Node, rewrite func(text string) string) bool {
+	changed := false
+	replace := func(value string) string {
+		rewritten := rewrite(value)
+		if rewritten != value {
+			changed = true
+		}
+		return rewritten
+	}
+
+	document.Walk(func(node *adf.Node) bool {
+		switch node.Type {
+		case adf.TypeText:
+			node.Text = replace(node.Text)
+			for _, mark := range node.Marks {
+				if mark.Type == adf.MarkLink {
+					mark.SetAttr("href", replace(mark.Attr("href")))
+				}
+			}
+		case adf.TypeInlineCard, adf.TypeBlockCard:
+			if address := node.Attr("url"); address != "" {
+				node.SetAttr("url", replace(address))
+			}
+		}
+		return true
+	})
+
+	return changed
+}
+
+// rewriteMediaNodes points the images and files embedded in a document to the target attachments, as the media of
+// the source instance cannot be read from the target
+func (s *migrator) rewriteMediaNodes(document *adf.Node, sourceIssue *jira.Issue, targetAttachments map[string]*jira.Attachment) bool {
+	changed := false
+	document.Walk(func(node *adf.Node) bool {
+		if node.Type != adf.TypeMedia {
+			return true
+		}
+
+		targetAttachment := s.findMediaAttachment(node, sourceIssue, targetAttachments)
+		if targetAttachment == nil {
+			return true
+		}
+
+		node.Attrs = map[string]interface{}{
+			"type": "external",
+			"url":  fmt.Sprintf("%s/secure/attachment/%s/%s", siteURL(s.targetClient), targetAttachment.ID, url.PathEscape(targetAttachment.Filename)),
+			"alt":  targetAttachment.Filename,
+		}
+		changed = true
+		return true
+	})
+
+	return changed
+}
+
+// findMediaAttachment returns the target attachment of the source attachment embedded by a media node. External
+// media are found by the attachment ID in their URL. File media are identified by a Media Services ID unrelated to
+// the attachment ID, so they are found by their file name, only when no other source attachment has the same name.
+func (s *migrator) findMediaAttachment(node *adf.Node, sourceIssue *jira.Issue, targetAttachments map[string]*jira.Attachment) *jira.Attachment {
+	switch node.Attr("type") {
+	case "file":
+	case "external":
+		if match := s.attachmentURLPattern().FindStringSubmatch(node.Attr("url")); match != nil {
+			return targetAttachments[match[2]]
+		}
+		return nil
+	default:
+		return nil
+	}
+
+	alt := node.Attr("alt")
+	if alt == "" {
+		return nil
+	}
+
+	var targetAttachment *jira.Attachment
+	for _, sourceAttachment := range sourceIssue.Fields.Attachments {
+		if sourceAttachment == nil || sourceAttachment.Filename != alt {
+			continue
+		}
+
+		if targetAttachment != nil {
+			return nil
+		}
+
+		var ok bool
+		if targetAttachment, ok = targetAttachments[sourceAttachment.ID]; !ok {
+			return nil
+		}
+	}
+
+	return targetAttachment
+}
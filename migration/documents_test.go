@@ -0,0 +1,50 @@
+package migration
+
+import (
+	"testing"
+
+	"github.com/natenho/go-jira"
+	"github.com/natenho/go-jira-migrate/internal/adf"
+)
+
+func TestRewriteMediaNodesMatchesFilesByName(t *testing.T) {
+	source := newFakeJira(t, nil)
+	target := newFakeJira(t, nil)
+	s := newTestMigrator(t, source, target)
+
+	sourceIssue := &jira.Issue{Key: "SRC-1", Fields: &jira.IssueFields{Attachments: []*jira.Attachment{
+		{ID: "100", Filename: "diagram.png"},
+		{ID: "101", Filename: "screenshot.png"},
+		{ID: "102", Filename: "screenshot.png"},
+	}}}
+
+	targetAttachments := map[string]*jira.Attachment{
+		"100": {ID: "200", Filename: "diagram.png"},
+		"101": {ID: "201", Filename: "screenshot.png"},
+		"102": {ID: "202", Filename: "screenshot (1).png"},
+	}
+
+	// Cloud identifies uploaded media by a Media Services ID, not by the attachment ID
+	diagram := &adf.Node{Type: adf.TypeMedia, Attrs: map[string]interface{}{
+		"type": "file", "id": "6e7c3b5a-1f2d-4c8e-9a0b-123456789abc", "collection": "jira-10001", "alt": "diagram.png"}}
+	screenshot := &adf.Node{Type: adf.TypeMedia, Attrs: map[string]interface{}{
+		"type": "file", "id": "0b1c2d3e-4f50-4a6b-8c7d-9e0f1a2b3c4d", "collection": "jira-10001", "alt": "screenshot.png"}}
+
+	document := &adf.Node{Type: "doc", Content: []*adf.Node{
+		{Type: "mediaSingle", Content: []*adf.Node{diagram}},
+		{Type: "mediaSingle", Content: []*adf.Node{screenshot}},
+	}}
+
+	if !s.rewriteMediaNodes(document, sourceIssue, targetAttachments) {
+		t.Fatal("got the document unchanged, want the diagram rewritten")
+	}
+
+	if got, want := diagram.Attr("url"), target.server.URL+"/secure/attachment/200/diagram.png"; got != want {
+		t.Errorf("got diagram url %q, want %q", got, want)
+	}
+
+	// Several attachments have the name of the screenshot, so it cannot tell which one it is
+	if got := screenshot.Attr("type"); got != "file" {
+		t.Errorf("got screenshot type %q, want it left as a file", got)
+	}
+}
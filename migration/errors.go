@@ -0,0 +1,137 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/natenho/go-jira"
+	"github.com/pkg/errors"
+)
+
+// Kinds of migration failures, detected with errors.Is. Details of the failures answered by Jira are
+// available with errors.As on a *ResponseError.
+var (
+	ErrRateLimited      = errors.New("rate limited")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrNotFound         = errors.New("not found")
+	ErrValidation       = errors.New("validation failed")
+	ErrPayloadTooLarge  = errors.New("payload too large")
+	ErrAlreadyMigrated  = errors.New("issue already migrated")
+	// ErrTransient is a server or network failure that may succeed when retried
+	ErrTransient = errors.New("transient failure")
+)
+
+var errorKinds = []error{ErrRateLimited, ErrPermissionDenied, ErrNotFound, ErrValidation, ErrPayloadTooLarge, ErrAlreadyMigrated, ErrTransient}
+
+// ResponseError is a failed request to Jira, classified by its HTTP status
+type ResponseError struct {
+	Operation  string
+	StatusCode int
+	// Messages are the errorMessages of the response
+	Messages []string
+	// FieldErrors are the errors of the response, per field name
+	FieldErrors map[string]string
+	Body        string
+	Err         error
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Operation, e.Err, e.Body)
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the kind of the error, so errors.Is(err, ErrNotFound) tells whether Jira answered 404
+func (e *ResponseError) Is(target error) bool {
+	return target != nil && target == e.Kind()
+}
+
+// Kind returns the kind of the failure, or nil when the status is not classified
+func (e *ResponseError) Kind() error {
+	switch {
+	case e.StatusCode == 0:
+		return ErrTransient
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrPermissionDenied
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity:
+		return ErrValidation
+	case e.StatusCode == http.StatusRequestEntityTooLarge:
+		return ErrPayloadTooLarge
+	case e.StatusCode >= http.StatusInternalServerError:
+		return ErrTransient
+	default:
+		return nil
+	}
+}
+
+// Fields returns the names of the fields that failed validation
+func (e *ResponseError) Fields() []string {
+	fields := make([]string, 0, len(e.FieldErrors))
+	for field := range e.FieldErrors {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+	return fields
+}
+
+// ErrorKind returns a short name of the kind of the error, for reports
+func ErrorKind(err error) string {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind) {
+			return strings.ReplaceAll(kind.Error(), " ", "-")
+		}
+	}
+
+	return "other"
+}
+
+// parseResponseError turns the error of a request into a *ResponseError described by the response body.
+// Errors parsed already are returned unchanged, as the body can only be read once.
+func parseResponseError(operation string, response *jira.Response, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var responseErr *ResponseError
+	if errors.As(err, &responseErr) {
+		return err
+	}
+
+	responseErr = &ResponseError{Operation: operation, Err: err}
+
+	if response == nil || response.Response == nil {
+		return responseErr
+	}
+
+	responseErr.StatusCode = response.StatusCode
+
+	responseBody, _ := io.ReadAll(response.Body)
+
+	var out bytes.Buffer
+	_ = json.Indent(&out, responseBody, "", "  ")
+	responseErr.Body = out.String()
+
+	var jiraErr struct {
+		ErrorMessages []string          `json:"errorMessages"`
+		Errors        map[string]string `json:"errors"`
+	}
+
+	if json.Unmarshal(responseBody, &jiraErr) == nil {
+		responseErr.Messages = jiraErr.ErrorMessages
+		responseErr.FieldErrors = jiraErr.Errors
+	}
+
+	return responseErr
+}
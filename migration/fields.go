@@ -2,6 +2,8 @@ package migration
 
 import (
 	"fmt"
+	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/natenho/go-jira"
@@ -29,12 +31,12 @@ func (s *migrator) discoverFields() error {
 		return err
 	}
 
-	s.sourceFieldPerIssueType, err = getAvailableFieldsPerIssueType(s.sourceClient, s.sourceProjectKey)
+	s.sourceFieldPerIssueType, err = getAvailableFieldsPerIssueType(s.sourceClient, s.sourceInstance, s.sourceProjectKey)
 	if err != nil {
 		return err
 	}
 
-	s.targetFieldPerIssueType, err = getAvailableFieldsPerIssueType(s.targetClient, s.targetProjectKey)
+	s.targetFieldPerIssueType, err = getAvailableFieldsPerIssueType(s.targetClient, s.targetInstance, s.targetProjectKey)
 	if err != nil {
 		return err
 	}
@@ -42,7 +44,12 @@ func (s *migrator) discoverFields() error {
 	return nil
 }
 
-func getAvailableFieldsPerIssueType(client *jira.Client, projectKey string) (map[string][]jira.Field, error) {
+func getAvailableFieldsPerIssueType(client *jira.Client, site *instance, projectKey string) (map[string][]jira.Field, error) {
+	// Data Center 9 removed the fields of the create metadata, which are read per issue type instead
+	if !site.isCloud() && site.atLeast(9) {
+		return getServerAvailableFieldsPerIssueType(client, projectKey)
+	}
+
 	availableFieldsMap := map[string][]jira.Field{}
 
 	meta, response, err := client.Issue.GetCreateMeta(projectKey)
@@ -60,12 +67,13 @@ func getAvailableFieldsPerIssueType(client *jira.Client, projectKey string) (map
 
 			fieldName, _ := issueType.Fields.String(fieldKey + "/name")
 			customSchema, _ := issueType.Fields.String(fieldKey + "/schema/custom")
+			schemaType, _ := issueType.Fields.String(fieldKey + "/schema/type")
 
 			field := jira.Field{
 				Key:    fieldKey,
 				Name:   fieldName,
 				Custom: customSchema != "",
-				Schema: jira.FieldSchema{Custom: customSchema}}
+				Schema: jira.FieldSchema{Type: schemaType, Custom: customSchema}}
 
 			availableFieldsMap[issueType.Name] = append(availableFieldsMap[issueType.Name], field)
 		}
@@ -74,6 +82,73 @@ func getAvailableFieldsPerIssueType(client *jira.Client, projectKey string) (map
 	return availableFieldsMap, err
 }
 
+// serverCreateMetaPage is a page of the create metadata of Data Center, listing issue types or their fields
+type serverCreateMetaPage struct {
+	StartAt int                     `json:"startAt"`
+	IsLast  bool                    `json:"isLast"`
+	Values  []serverCreateMetaValue `json:"values"`
+}
+
+type serverCreateMetaValue struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	FieldID string `json:"fieldId"`
+	Schema  struct {
+		Type   string `json:"type"`
+		Custom string `json:"custom"`
+	} `json:"schema"`
+}
+
+func getServerAvailableFieldsPerIssueType(client *jira.Client, projectKey string) (map[string][]jira.Field, error) {
+	availableFieldsMap := map[string][]jira.Field{}
+
+	issueTypes, err := getServerCreateMeta(client, fmt.Sprintf("rest/api/2/issue/createmeta/%s/issuetypes", projectKey))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, issueType := range issueTypes {
+		fields, err := getServerCreateMeta(client, fmt.Sprintf("rest/api/2/issue/createmeta/%s/issuetypes/%s", projectKey, issueType.ID))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, field := range fields {
+			availableFieldsMap[issueType.Name] = append(availableFieldsMap[issueType.Name], jira.Field{
+				Key:    field.FieldID,
+				Name:   field.Name,
+				Custom: field.Schema.Custom != "",
+				Schema: jira.FieldSchema{Type: field.Schema.Type, Custom: field.Schema.Custom}})
+		}
+	}
+
+	return availableFieldsMap, nil
+}
+
+// getServerCreateMeta reads every page of a Data Center create metadata endpoint
+func getServerCreateMeta(client *jira.Client, apiEndpoint string) ([]serverCreateMetaValue, error) {
+	var values []serverCreateMetaValue
+
+	for {
+		request, err := client.NewRequest(http.MethodGet, fmt.Sprintf("%s?startAt=%d&maxResults=%d", apiEndpoint, len(values), maxResultsPerSearch), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		page := &serverCreateMetaPage{}
+		response, err := client.Do(request, page)
+		if err != nil {
+			return nil, parseResponseError("GetCreateMeta", response, err)
+		}
+
+		values = append(values, page.Values...)
+
+		if page.IsLast || len(page.Values) == 0 {
+			return values, nil
+		}
+	}
+}
+
 func (s *migrator) mapCustomFields() error {
 	sourceFields, response, err := s.sourceClient.Field.GetList()
 	if err != nil {
@@ -104,10 +179,11 @@ func areEquivalentFields(a, b jira.Field) bool {
 	return strings.EqualFold(b.Name, a.Name) && b.Schema.Custom == a.Schema.Custom
 }
 
-// TODO Improve this via configuration
 func (s *migrator) areMappedFields(sourceField, targetField jira.Field) bool {
-	if sourceField.Name == "Story Points" && targetField.Name == "Story point estimate" {
-		return true
+	for _, targetFieldName := range s.fieldMappings[strings.ToLower(sourceField.Name)] {
+		if strings.EqualFold(targetFieldName, targetField.Name) {
+			return true
+		}
 	}
 
 	return false
@@ -123,11 +199,45 @@ func (s *migrator) getSourceFieldsFromTargetFieldKey(targetFieldKey string) []st
 		}
 	}
 
+	sort.Strings(sourceFieldKeys)
+
 	return sourceFieldKeys
 }
 
+// getTargetFieldValue returns the value of the target field collapsed from every source field mapped to it, the
+// first one with a value wins. It is not set when no source field is mapped to it, or when it has a fixed value and
+// none of them has a value.
+func (s *migrator) getTargetFieldValue(sourceIssue *jira.Issue, targetField jira.Field) (interface{}, bool) {
+	sourceFieldKeys := s.getSourceFieldsFromTargetFieldKey(targetField.Key)
+	if len(sourceFieldKeys) == 0 {
+		return nil, false
+	}
+
+	var sourceValue interface{}
+	for _, sourceFieldKey := range sourceFieldKeys {
+		if sourceValue = sourceIssue.Fields.Unknowns[sourceFieldKey]; sourceValue != nil {
+			break
+		}
+	}
+
+	if fixedValue, ok := s.fieldValues[targetField.Name]; ok {
+		return fixedValue, sourceValue != nil
+	}
+
+	if fieldValue, ok := sourceValue.(map[string]interface{}); ok {
+		delete(fieldValue, "id")
+		delete(fieldValue, "self")
+	}
+
+	return sourceValue, true
+}
+
 func (s *migrator) getCustomFieldValue(issue *jira.Issue, fieldName string) any {
-	field, ok := internal.SliceFind(s.sourceFieldPerIssueType[issue.Fields.Type.Name], func(field jira.Field) bool {
+	return getFieldValueByName(s.sourceFieldPerIssueType, issue, fieldName)
+}
+
+func getFieldValueByName(fieldPerIssueType map[string][]jira.Field, issue *jira.Issue, fieldName string) any {
+	field, ok := internal.SliceFind(fieldPerIssueType[issue.Fields.Type.Name], func(field jira.Field) bool {
 		return field.Name == fieldName
 	})
 
@@ -0,0 +1,391 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/natenho/go-jira"
+	"github.com/natenho/go-jira-migrate/internal/adf"
+	"github.com/pkg/errors"
+)
+
+const (
+	// HistoryComment exports the change history as a comment, collapsed when documents are migrated
+	HistoryComment = "comment"
+	// HistoryJSON exports the change history as a JSON attachment
+	HistoryJSON = "json"
+	// HistoryCSV exports the change history as a CSV attachment
+	HistoryCSV = "csv"
+)
+
+// historyCommentLimit keeps the history comment under the maximum length of a comment
+const historyCommentLimit = 30000
+
+// historyValueLimit truncates the values shown in the history comment, such as old descriptions
+const historyValueLimit = 200
+
+// WithHistory exports the change history of the source issues to the target issues, in the given formats
+func WithHistory(formats ...string) Option {
+	return func(m *migrator) {
+		m.historyFormats = formats
+	}
+}
+
+// historyChange is a field changed on the source issue, the row of the exported change history
+type historyChange struct {
+	HistoryID  string `json:"historyId"`
+	Created    string `json:"created"`
+	Author     string `json:"author"`
+	AuthorID   string `json:"authorId,omitempty"`
+	Field      string `json:"field"`
+	From       string `json:"from,omitempty"`
+	FromString string `json:"fromString,omitempty"`
+	To         string `json:"to,omitempty"`
+	ToString   string `json:"toString,omitempty"`
+
+	// author is nil for the changes made without a user, such as anonymous changes and automation
+	author *jira.User
+}
+
+type changelogPage struct {
+	StartAt    int                     `json:"startAt"`
+	MaxResults int                     `json:"maxResults"`
+	Total      int                     `json:"total"`
+	Histories  []jira.ChangelogHistory `json:"histories"`
+	// Values are the histories of the changelog endpoint
+	Values []jira.ChangelogHistory `json:"values"`
+}
+
+// getChangelog reads the change history of an issue, oldest first. The changelog expanded by Cloud is truncated, so
+// the remaining pages are read from the changelog endpoint.
+func (s *migrator) getChangelog(issueKey string) ([]jira.ChangelogHistory, error) {
+	request, err := s.sourceClient.NewRequest(http.MethodGet, fmt.Sprintf("rest/api/2/issue/%s?fields=created&expand=changelog", url.PathEscape(issueKey)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	issue := &struct {
+		Changelog changelogPage `json:"changelog"`
+	}{}
+
+	response, err := s.sourceClient.Do(request, issue)
+	if err != nil {
+		return nil, parseResponseError("GetChangelog", response, err)
+	}
+	response.Body.Close()
+
+	histories := issue.Changelog.Histories
+
+	for s.sourceInstance.isCloud() && len(histories) < issue.Changelog.Total {
+		request, err := s.sourceClient.NewRequest(http.MethodGet, fmt.Sprintf("rest/api/2/issue/%s/changelog?startAt=%d&maxResults=%d", url.PathEscape(issueKey), len(histories), maxResultsPerSearch), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		page := &changelogPage{}
+		response, err := s.sourceClient.Do(request, page)
+		if err != nil {
+			return nil, parseResponseError("GetChangelog", response, err)
+		}
+		response.Body.Close()
+
+		if len(page.Values) == 0 {
+			break
+		}
+
+		histories = append(histories, page.Values...)
+	}
+
+	// The changelog is returned oldest first, except by some Server versions
+	sort.SliceStable(histories, func(i, j int) bool {
+		return historyTime(histories[i]).Before(historyTime(histories[j]))
+	})
+
+	return histories, nil
+}
+
+func historyTime(history jira.ChangelogHistory) time.Time {
+	created, _ := time.Parse(jiraTimeLayout, history.Created)
+	return created
+}
+
+func getHistoryChanges(histories []jira.ChangelogHistory) []historyChange {
+	var changes []historyChange
+	for _, history := range histories {
+		author := history.Author
+		for _, item := range history.Items {
+			change := historyChange{
+				HistoryID:  history.Id,
+				Created:    history.Created,
+				Author:     author.DisplayName,
+				AuthorID:   userID(&author),
+				Field:      item.Field,
+				From:       formatHistoryValue(item.From),
+				FromString: item.FromString,
+				To:         formatHistoryValue(item.To),
+				ToString:   item.ToString,
+				author:     &author,
+			}
+
+			if change.AuthorID == "" {
+				change.Author = historyAuthorName(author)
+				change.author = nil
+			}
+
+			changes = append(changes, change)
+		}
+	}
+
+	return changes
+}
+
+// historyAuthorName names the author of a change made without a user, anonymously or by automation, by its display
+// name when it has one
+func historyAuthorName(author jira.User) string {
+	if author.DisplayName != "" {
+		return author.DisplayName
+	}
+
+	return "Anonymous"
+}
+
+func formatHistoryValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
+
+// migrateHistory writes the change history of the source issue to the target issue in every configured format.
+// Exports of earlier runs are replaced, so synced issues show the latest history.
+func (s *migrator) migrateHistory(sourceIssue *jira.Issue, targetIssue *jira.Issue) error {
+	if len(s.historyFormats) == 0 {
+		return nil
+	}
+
+	histories, err := s.getChangelog(sourceIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	changes := getHistoryChanges(histories)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	entry, _ := s.ledger.Get(sourceIssue.Key)
+
+	for _, format := range s.historyFormats {
+		var targetID string
+		switch format {
+		case HistoryComment:
+			targetID, err = s.writeHistoryComment(sourceIssue, targetIssue, changes, entry.Items[StepHistory][format])
+		case HistoryJSON, HistoryCSV:
+			targetID, err = s.writeHistoryAttachment(sourceIssue, targetIssue, changes, format, entry.Items[StepHistory][format])
+		default:
+			err = errors.Errorf("unknown history format %q", format)
+		}
+
+		// The new export is recorded even when the previous one could not be deleted
+		if targetID != "" {
+			if ledgerErr := s.ledger.SetItem(sourceIssue.Key, StepHistory, format, targetID); err == nil {
+				err = ledgerErr
+			}
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "could not export %s history", format)
+		}
+	}
+
+	return nil
+}
+
+// writeHistoryComment adds the history comment, or updates the one added by an earlier run
+func (s *migrator) writeHistoryComment(sourceIssue *jira.Issue, targetIssue *jira.Issue, changes []historyChange, commentID string) (string, error) {
+	if s.adf {
+		body := s.buildHistoryDocument(sourceIssue, changes)
+		if commentID != "" {
+			return commentID, updateCommentDocument(s.targetClient, targetIssue.ID, commentID, body)
+		}
+
+		createdComment, err := addCommentDocument(s.targetClient, targetIssue.ID, &commentDocument{Body: body})
+		if err != nil {
+			return "", err
+		}
+
+		return createdComment.ID, nil
+	}
+
+	comment := &jira.Comment{ID: commentID, Body: s.buildHistoryComment(sourceIssue, changes)}
+	if commentID != "" {
+		_, response, err := s.targetClient.Issue.UpdateComment(targetIssue.ID, comment)
+		if err != nil {
+			return "", parseResponseError("UpdateComment", response, err)
+		}
+
+		return commentID, nil
+	}
+
+	createdComment, response, err := s.targetClient.Issue.AddComment(targetIssue.ID, comment)
+	if err != nil {
+		return "", parseResponseError("AddComment", response, err)
+	}
+
+	return createdComment.ID, nil
+}
+
+// buildHistoryComment returns the history as a wiki markup table, with a row per field change
+func (s *migrator) buildHistoryComment(sourceIssue *jira.Issue, changes []historyChange) string {
+	escape := strings.NewReplacer("|", "\\|", "\r", "", "\n", " ", "{", "\\{", "[", "\\[")
+	cell := func(value string) string {
+		if value = escape.Replace(truncateHistoryValue(value)); value == "" {
+			return " "
+		}
+		return value
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "_Change history of the original issue %s_\n\n||Date||Author||Field||From||To||\n", sourceIssue.Key)
+
+	for i, change := range changes {
+		author := cell(change.Author)
+		if change.author != nil {
+			author = s.mention(change.author)
+		}
+
+		row := fmt.Sprintf("|%s|%s|%s|%s|%s|\n", change.Created, author, cell(change.Field), cell(change.FromString), cell(change.ToString))
+		if builder.Len()+len(row) > historyCommentLimit {
+			fmt.Fprintf(&builder, "\n_%d more changes are not shown_", len(changes)-i)
+			break
+		}
+
+		builder.WriteString(row)
+	}
+
+	return builder.String()
+}
+
+// buildHistoryDocument is the document counterpart of buildHistoryComment, collapsing the table
+func (s *migrator) buildHistoryDocument(sourceIssue *jira.Issue, changes []historyChange) *adf.Node {
+	cell := func(value string) *adf.Node {
+		if value = truncateHistoryValue(value); value == "" {
+			return adf.TableCell(adf.Paragraph())
+		}
+		return adf.TableCell(adf.Paragraph(adf.Text(value)))
+	}
+
+	rows := []*adf.Node{adf.TableRow(
+		adf.TableHeader(adf.Paragraph(adf.Text("Date"))),
+		adf.TableHeader(adf.Paragraph(adf.Text("Author"))),
+		adf.TableHeader(adf.Paragraph(adf.Text("Field"))),
+		adf.TableHeader(adf.Paragraph(adf.Text("From"))),
+		adf.TableHeader(adf.Paragraph(adf.Text("To"))),
+	)}
+
+	// The document is far longer than its text, so the text is kept well under the limit of a comment
+	var length int
+	var omitted *adf.Node
+	for i, change := range changes {
+		length += len(change.Created) + len(change.Field) + len(truncateHistoryValue(change.FromString)) + len(truncateHistoryValue(change.ToString))
+		if length > historyCommentLimit/4 {
+			omitted = adf.Paragraph(adf.Text(fmt.Sprintf("%d more changes are not shown", len(changes)-i), adf.Em()))
+			break
+		}
+
+		author := cell(change.Author)
+		if change.author != nil {
+			author = adf.TableCell(adf.Paragraph(s.mentionNode(change.author)))
+		}
+
+		rows = append(rows, adf.TableRow(
+			cell(change.Created),
+			author,
+			cell(change.Field),
+			cell(change.FromString),
+			cell(change.ToString),
+		))
+	}
+
+	expand := adf.Expand(fmt.Sprintf("Change history of the original issue %s", sourceIssue.Key), adf.Table(rows...))
+	if omitted != nil {
+		expand.Append(omitted)
+	}
+
+	return adf.Doc(expand)
+}
+
+func truncateHistoryValue(value string) string {
+	runes := []rune(value)
+	if len(runes) <= historyValueLimit {
+		return value
+	}
+
+	return string(runes[:historyValueLimit]) + "…"
+}
+
+// writeHistoryAttachment uploads the history file, then deletes the one uploaded by an earlier run
+func (s *migrator) writeHistoryAttachment(sourceIssue *jira.Issue, targetIssue *jira.Issue, changes []historyChange, format, attachmentID string) (string, error) {
+	content := &bytes.Buffer{}
+
+	switch format {
+	case HistoryJSON:
+		encoder := json.NewEncoder(content)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(changes); err != nil {
+			return "", err
+		}
+	case HistoryCSV:
+		writer := csv.NewWriter(content)
+		writer.Write([]string{"historyId", "created", "author", "authorId", "field", "from", "fromString", "to", "toString"})
+		for _, change := range changes {
+			writer.Write([]string{change.HistoryID, change.Created, change.Author, change.AuthorID, change.Field, change.From, change.FromString, change.To, change.ToString})
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return "", err
+		}
+	}
+
+	filename := fmt.Sprintf("%s-history.%s", sourceIssue.Key, format)
+	createdAttachments, response, err := s.targetClient.Issue.PostAttachment(targetIssue.ID, content, filename)
+	if err != nil {
+		return "", parseResponseError(fmt.Sprintf("PostAttachment(%s)", filename), response, err)
+	}
+	defer response.Body.Close()
+
+	if createdAttachments == nil || len(*createdAttachments) == 0 {
+		return "", errors.Errorf("PostAttachment(%s): no attachment created", filename)
+	}
+
+	createdID := (*createdAttachments)[0].ID
+
+	if attachmentID != "" {
+		response, err := s.targetClient.Issue.DeleteAttachment(attachmentID)
+		if err = parseResponseError("DeleteAttachment", response, err); err != nil && !errors.Is(err, ErrNotFound) {
+			return createdID, err
+		}
+	}
+
+	return createdID, nil
+}
+
+// isHistoryExport tells whether a target comment or attachment is an export of the change history
+func (s *migrator) isHistoryExport(sourceKey, targetID string) bool {
+	entry, _ := s.ledger.Get(sourceKey)
+	for _, exportID := range entry.Items[StepHistory] {
+		if exportID == targetID {
+			return true
+		}
+	}
+
+	return false
+}
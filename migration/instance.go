@@ -0,0 +1,119 @@
+package migration
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/natenho/go-jira"
+)
+
+const (
+	deploymentCloud      = "Cloud"
+	deploymentServer     = "Server"
+	deploymentDataCenter = "DataCenter"
+)
+
+// instance describes a Jira site, which is either Cloud or Server / Data Center
+type instance struct {
+	DeploymentType string `json:"deploymentType"`
+	Version        string `json:"version"`
+	VersionNumbers []int  `json:"versionNumbers"`
+}
+
+// getInstance reads the deployment type and version of a site from its serverInfo
+func getInstance(client *jira.Client) (*instance, error) {
+	request, err := client.NewRequest(http.MethodGet, "rest/api/2/serverInfo", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &instance{}
+	response, err := client.Do(request, info)
+	if err != nil {
+		return nil, parseResponseError("ServerInfo", response, err)
+	}
+
+	// Old Server versions do not tell the deployment type
+	if info.DeploymentType == "" {
+		info.DeploymentType = deploymentServer
+	}
+
+	return info, nil
+}
+
+func (i *instance) isCloud() bool {
+	return i.DeploymentType == deploymentCloud
+}
+
+// atLeast tells whether a Server / Data Center version is the given major version or a later one
+func (i *instance) atLeast(major int) bool {
+	return len(i.VersionNumbers) > 0 && i.VersionNumbers[0] >= major
+}
+
+func (i *instance) String() string {
+	return fmt.Sprintf("%s %s", i.DeploymentType, i.Version)
+}
+
+// userID returns the identifier of a user on its own site, the account ID on Cloud and the user name on Server
+func userID(user *jira.User) string {
+	switch {
+	case user.AccountID != "":
+		return user.AccountID
+	case user.Name != "":
+		return user.Name
+	default:
+		return user.Key
+	}
+}
+
+// newUser returns a reference to a user of the site, by account ID on Cloud and by user name on Server
+func (i *instance) newUser(id string) *jira.User {
+	if i.isCloud() {
+		return &jira.User{AccountID: id}
+	}
+
+	return &jira.User{Name: id}
+}
+
+// getUser reads an user of the site by its identifier
+func (i *instance) getUser(client *jira.Client, id string) (*jira.User, error) {
+	if i.isCloud() {
+		user, response, err := client.User.GetByAccountID(id)
+		return user, parseResponseError("GetUser", response, err)
+	}
+
+	request, err := client.NewRequest(http.MethodGet, "rest/api/2/user?username="+url.QueryEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &jira.User{}
+	response, err := client.Do(request, user)
+	if err != nil {
+		return nil, parseResponseError("GetUser", response, err)
+	}
+
+	return user, nil
+}
+
+// findUsers searches the users of the site by email address or name
+func (i *instance) findUsers(client *jira.Client, query string) ([]jira.User, error) {
+	parameter := "username"
+	if i.isCloud() {
+		parameter = "query"
+	}
+
+	request, err := client.NewRequest(http.MethodGet, fmt.Sprintf("rest/api/2/user/search?%s=%s&maxResults=%d", parameter, url.QueryEscape(query), maxResultsPerSearch), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []jira.User
+	response, err := client.Do(request, &users)
+	if err != nil {
+		return nil, parseResponseError("FindUsers", response, err)
+	}
+
+	return users, nil
+}
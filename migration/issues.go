@@ -3,18 +3,25 @@ package migration
 import (
 	"fmt"
 	"net/url"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/natenho/go-jira"
-	"github.com/natenho/go-jira-migrate/internal"
 	"github.com/pkg/errors"
 	"github.com/trivago/tgo/tcontainer"
+	"golang.org/x/exp/slices"
 )
 
-func (s *migrator) migrateIssue(issueKey string) Result {
-	result := Result{}
+func (s *migrator) migrateIssue(issueKey string) (result Result) {
+	defer func(startedAt time.Time) {
+		result.Duration = time.Since(startedAt)
+
+		if s.rewriteReferences && result.TargetKey != "" && !result.HasError(ErrAlreadyMigrated) {
+			if err := s.ledger.SetItem(result.SourceKey, StepReferences, referencesIssueItem, s.runID); err != nil {
+				result.Errors = append(result.Errors, err)
+			}
+		}
+	}(time.Now())
 
 	mutex, _ := s.syncRoot.LoadOrStore(issueKey, &sync.Mutex{})
 	mutex.(*sync.Mutex).Lock()
@@ -33,16 +40,34 @@ func (s *migrator) migrateIssue(issueKey string) Result {
 
 	result.SourceKey = sourceIssue.Key
 	result.SourceSummary = sourceIssue.Fields.Summary
+	result.IssueType = sourceIssue.Fields.Type.Name
 
-	existingIssue, err := s.findTargetIssueBySummaryAndDescription(sourceIssue.Fields.Summary, sourceIssue.Key)
-	if err != nil {
-		result.Errors = append(result.Errors, err)
-		return result
-	}
+	if entry, ok := s.ledger.Get(sourceIssue.Key); ok && entry.TargetKey != "" {
+		result.TargetKey = entry.TargetKey
+
+		if s.sync {
+			targetIssue, err := s.getTargetIssueByKey(entry.TargetKey)
+			if err != nil {
+				result.Errors = append(result.Errors, err)
+				return result
+			}
+
+			s.updateIssue(&result, sourceIssue, targetIssue, entry)
+			return result
+		}
+
+		if !s.resume || entry.IsComplete() {
+			result.Errors = append(result.Errors, errors.Wrapf(ErrAlreadyMigrated, "%s was migrated to %s", sourceIssue.Key, entry.TargetKey))
+			return result
+		}
 
-	if existingIssue != nil {
-		result.TargetKey = existingIssue.Key
-		result.Errors = append(result.Errors, errors.New("issue already exists"))
+		targetIssue, err := s.getTargetIssueByKey(entry.TargetKey)
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			return result
+		}
+
+		s.migrateSteps(&result, sourceIssue, targetIssue, entry)
 		return result
 	}
 
@@ -62,73 +87,183 @@ func (s *migrator) migrateIssue(issueKey string) Result {
 
 	err = parseResponseError("Create", response, err)
 
-	if err != nil && isUserCannotBeAssignedError(err) { //TODO Improve this
+	if isUserCannotBeAssignedError(err) {
 		targetIssue.Fields.Assignee = s.currentUser
 		targetIssue.Fields.Description = fmt.Sprintf(
-			"%s\n\n{color:red}_Original issue assigned to [~accountid:%s]_{color}",
+			"%s\n\n{color:red}_Original issue assigned to %s_{color}",
 			targetIssue.Fields.Description,
-			sourceIssue.Fields.Assignee.AccountID)
+			s.mention(sourceIssue.Fields.Assignee))
 
 		createdIssue, response, err = s.targetClient.Issue.Create(targetIssue)
 		defer response.Body.Close()
 	}
 
 	if err != nil {
-		err = errors.Wrapf(err, "could not migrate %s", sourceIssue.Key)
-
-		result.Errors = append(result.Errors, parseResponseError("Create", response, err))
+		result.Errors = append(result.Errors, errors.Wrapf(parseResponseError("Create", response, err), "could not migrate %s", sourceIssue.Key))
 		return result
 	}
 
 	result.TargetKey = createdIssue.Key
 
-	if err := s.setupTargetSprint(sourceIssue, createdIssue); err != nil {
+	if err := s.ledger.SetTarget(sourceIssue.Key, createdIssue.Key, s.runID); err != nil {
 		result.Errors = append(result.Errors, err)
 	}
 
-	for err := range s.migrateComments(sourceIssue, createdIssue) {
-		if err != nil {
+	parentKey := s.getTargetParentKey(targetIssue)
+
+	if err := s.recordObject(LedgerObject{Kind: ObjectIssue, ID: createdIssue.Key, IssueKey: createdIssue.Key, SourceKey: sourceIssue.Key, ParentKey: parentKey}); err != nil {
+		result.Errors = append(result.Errors, err)
+	}
+
+	s.recordStep(&result, StepCreate, nil)
+	s.migrateSteps(&result, sourceIssue, createdIssue, LedgerEntry{})
+
+	if s.deleteOnError && len(result.Errors) > 0 {
+		if response, err := s.targetClient.Issue.Delete(createdIssue.Key); err != nil {
+			if err != nil {
+				result.Errors = append(result.Errors, parseResponseError("delete", response, err))
+				return result
+			}
+		}
+
+		// The deleted issue has no references to rewrite
+		result.TargetKey = ""
+
+		if err := s.ledger.Forget(sourceIssue.Key); err != nil {
 			result.Errors = append(result.Errors, err)
 		}
-	}
 
-	for err := range s.migrateAttachments(sourceIssue, createdIssue) {
-		if err != nil {
+		if err := s.ledger.RemoveObject(LedgerObject{Kind: ObjectIssue, ID: createdIssue.Key}); err != nil {
 			result.Errors = append(result.Errors, err)
 		}
+
+		result.Errors = append(result.Errors, errors.New("deleted"))
 	}
 
-	if err := s.linkToOriginalIssue(sourceIssue, createdIssue); err != nil {
-		result.Errors = append(result.Errors, err)
+	return result
+}
+
+// migrateSteps runs every migration step that follows the issue creation, skipping the ones already done
+func (s *migrator) migrateSteps(result *Result, sourceIssue, targetIssue *jira.Issue, entry LedgerEntry) {
+	steps := []struct {
+		name    string
+		migrate func() []error
+	}{
+		{StepDescription, func() []error { return []error{s.migrateDescription(sourceIssue, targetIssue)} }},
+		{StepSprint, func() []error { return []error{s.setupTargetSprint(sourceIssue, targetIssue)} }},
+		{StepComments, func() []error {
+			comments, errChan := s.migrateComments(sourceIssue, targetIssue)
+			result.Comments = append(result.Comments, comments...)
+			return collectErrors(errChan)
+		}},
+		{StepWorklogs, func() []error { return collectErrors(s.migrateWorklogs(sourceIssue, targetIssue)) }},
+		{StepAttachments, func() []error {
+			targetAttachments, errChan := s.migrateAttachments(sourceIssue, targetIssue)
+			return append(collectErrors(errChan), s.rewriteAttachmentReferences(sourceIssue, targetIssue, targetAttachments))
+		}},
+		{StepRemoteLink, func() []error { return []error{s.linkToOriginalIssue(sourceIssue, targetIssue)} }},
+		{StepLinks, func() []error { return collectErrors(s.migrateLinks(sourceIssue, targetIssue)) }},
+		{StepStatus, func() []error { return collectErrors(s.migrateStatus(sourceIssue, targetIssue)) }},
+		{StepHistory, func() []error { return []error{s.migrateHistory(sourceIssue, targetIssue)} }},
 	}
 
-	for err := range s.migrateLinks(sourceIssue, createdIssue) {
-		if err != nil {
-			result.Errors = append(result.Errors, err)
+	for _, step := range steps {
+		if entry.IsStepDone(step.name) {
+			result.Steps = append(result.Steps, StepResult{Name: step.name, Status: StepStatusSkipped})
+		} else {
+			s.recordStep(result, step.name, step.migrate()...)
+		}
+
+		s.countStepItems(sourceIssue, &result.Steps[len(result.Steps)-1])
+	}
+}
+
+// countStepItems counts the source items of the step and how many of them were migrated, by this run or earlier ones
+func (s *migrator) countStepItems(sourceIssue *jira.Issue, step *StepResult) {
+	switch step.Name {
+	case StepComments:
+		if sourceIssue.Fields.Comments == nil {
+			return
+		}
+
+		for _, comment := range sourceIssue.Fields.Comments.Comments {
+			step.Total++
+			if s.ledger.IsItemDone(sourceIssue.Key, StepComments, comment.ID) {
+				step.Migrated++
+			}
+		}
+	case StepWorklogs:
+		if sourceIssue.Fields.Worklog == nil {
+			return
+		}
+
+		entry, _ := s.ledger.Get(sourceIssue.Key)
+		step.Total = sourceIssue.Fields.Worklog.Total
+		step.Migrated = len(entry.Items[StepWorklogs])
+	case StepAttachments:
+		for _, attachment := range sourceIssue.Fields.Attachments {
+			if attachment == nil {
+				continue
+			}
+
+			step.Total++
+			if s.ledger.IsItemDone(sourceIssue.Key, StepAttachments, attachment.ID) {
+				step.Migrated++
+				step.Bytes += int64(attachment.Size)
+			}
+		}
+	case StepLinks:
+		for _, link := range sourceIssue.Fields.IssueLinks {
+			step.Total++
+			if s.ledger.IsItemDone(sourceIssue.Key, StepLinks, link.ID) {
+				step.Migrated++
+			}
 		}
 	}
+}
 
-	for err := range s.migrateStatus(sourceIssue, createdIssue) {
+// recordStep appends the step outcome to the result and records it in the ledger
+func (s *migrator) recordStep(result *Result, step string, errs ...error) {
+	stepResult := StepResult{Name: step, Status: StepStatusDone}
+
+	var stepErr error
+	for _, err := range errs {
 		if err != nil {
 			result.Errors = append(result.Errors, err)
+			stepResult.Status = StepStatusFailed
+			stepResult.Errors = append(stepResult.Errors, err.Error())
+			stepErr = err
 		}
 	}
 
-	if s.deleteOnError && len(result.Errors) > 0 {
-		if response, err := s.targetClient.Issue.Delete(createdIssue.Key); err != nil {
-			if err != nil {
-				result.Errors = append(result.Errors, parseResponseError("delete", response, err))
-				return result
-			}
+	result.Steps = append(result.Steps, stepResult)
+
+	if err := s.ledger.SetStep(result.SourceKey, step, stepErr); err != nil {
+		result.Errors = append(result.Errors, err)
+	}
+}
+
+// recordObject records something created on the target by the current run
+func (s *migrator) recordObject(object LedgerObject) error {
+	object.RunID = s.runID
+	return s.ledger.AddObject(object)
+}
+
+func collectErrors(errChan chan error) []error {
+	var errs []error
+	for err := range errChan {
+		if err != nil {
+			errs = append(errs, err)
 		}
-		result.Errors = append(result.Errors, errors.New("deleted"))
 	}
 
-	return result
+	return errs
 }
 
+// isUserCannotBeAssignedError tells whether Jira refused the assignee, as the user cannot be assigned issues in the target project
 func isUserCannotBeAssignedError(err error) bool {
-	return strings.Contains(strings.ToLower(err.Error()), "cannot be assigned issues")
+	var responseErr *ResponseError
+	return errors.Is(err, ErrValidation) && errors.As(err, &responseErr) && responseErr.FieldErrors["assignee"] != ""
 }
 
 func (s *migrator) getSourceIssueByKey(issueKey string) (*jira.Issue, error) {
@@ -141,93 +276,93 @@ func (s *migrator) getSourceIssueByKey(issueKey string) (*jira.Issue, error) {
 	return issue, nil
 }
 
+func (s *migrator) getTargetIssueByKey(issueKey string) (*jira.Issue, error) {
+	issue, response, err := s.targetClient.Issue.Get(issueKey, nil)
+	if err != nil {
+		return nil, parseResponseError("Get", response, err)
+	}
+	defer response.Body.Close()
+
+	return issue, nil
+}
+
 func (s *migrator) buildTargetIssue(sourceIssue *jira.Issue) (*jira.Issue, error) {
 	targetIssue := &jira.Issue{
 		Fields: &jira.IssueFields{
 			Type:        jira.IssueType{Name: sourceIssue.Fields.Type.Name},
 			Project:     jira.Project{Key: s.targetProjectKey},
-			Description: sourceIssue.Fields.Description,
+			Description: s.rewriteContent(sourceIssue.Fields.Description),
 			Summary:     sourceIssue.Fields.Summary,
-			Labels:      sourceIssue.Fields.Labels,
+			Labels:      s.getTargetLabels(sourceIssue.Fields.Labels),
 			Unknowns:    tcontainer.NewMarshalMap(),
 		},
 	}
 
 	if s.canSetAssignee(sourceIssue) {
-		targetIssue.Fields.Assignee = sourceIssue.Fields.Assignee
+		targetIssue.Fields.Assignee = s.mapUser(sourceIssue.Fields.Assignee)
 	} else if sourceIssue.Fields.Status.StatusCategory.Key == "done" {
 		targetIssue.Fields.Assignee = s.currentUser
 		if sourceIssue.Fields.Assignee != nil {
 			targetIssue.Fields.Description = fmt.Sprintf(
-				"%s\n\n{color:red}_Original issue assigned to [~accountid:%s]_{color}",
+				"%s\n\n{color:red}_Original issue assigned to %s_{color}",
 				targetIssue.Fields.Description,
-				sourceIssue.Fields.Assignee.AccountID)
+				s.mention(sourceIssue.Fields.Assignee))
 		}
 	}
 
 	if s.canSetReporter(sourceIssue) {
-		targetIssue.Fields.Reporter = sourceIssue.Fields.Reporter
+		targetIssue.Fields.Reporter = s.mapUser(sourceIssue.Fields.Reporter)
 	}
 
 	url := s.getSourceUrl(sourceIssue)
 	created := time.Time(sourceIssue.Fields.Created)
 	targetIssue.Fields.Description = fmt.Sprintf(
-		"%s\n\n{color:red}_Original issue [%s|%s] created on %s by [~accountid:%s]_{color}",
+		"%s\n\n{color:red}_Original issue [%s|%s] created on %s by %s_{color}",
 		targetIssue.Fields.Description,
 		sourceIssue.Key,
 		url,
 		created,
-		sourceIssue.Fields.Reporter.AccountID)
-
-	targetIssue.Fields.Labels = append(targetIssue.Fields.Labels, s.additionalLabels...)
+		s.mention(sourceIssue.Fields.Reporter))
 
 	if sourceIssue.Fields.Priority != nil && s.canMigrateField(sourceIssue.Fields.Type.Name, "priority") {
 		targetIssue.Fields.Priority = &jira.Priority{Name: sourceIssue.Fields.Priority.Name}
 	}
 
 	for _, targetField := range s.targetFieldPerIssueType[targetIssue.Fields.Type.Name] {
-		sourceFieldKeys := s.getSourceFieldsFromTargetFieldKey(targetField.Key)
-
-		if len(sourceFieldKeys) == 0 {
-			continue
+		if value, ok := s.getTargetFieldValue(sourceIssue, targetField); ok {
+			targetIssue.Fields.Unknowns[targetField.Key] = value
 		}
+	}
 
-		for _, sourceFieldKey := range sourceFieldKeys {
-
-			fieldValue, ok := sourceIssue.Fields.Unknowns[sourceFieldKey].(map[string]interface{})
-			if ok {
-				delete(fieldValue, "id")
-				delete(fieldValue, "self")
-				targetIssue.Fields.Unknowns[targetField.Key] = fieldValue
-				continue
-			}
+	s.setOriginalFields(sourceIssue, targetIssue)
 
-			if targetField.Name == "Flagged" && sourceIssue.Fields.Unknowns[sourceFieldKey] != nil { //TODO Get rid of this dark magic
-				targetIssue.Fields.Unknowns[targetField.Key] = []interface{}{map[string]interface{}{"value": "Impediment"}}
-				continue
-			}
+	return targetIssue, nil
+}
 
-			targetIssue.Fields.Unknowns[targetField.Key] = sourceIssue.Fields.Unknowns[sourceFieldKey]
+// getTargetLabels applies the label rules to the source labels and adds the additional labels
+func (s *migrator) getTargetLabels(sourceLabels []string) []string {
+	var targetLabels []string
+	for _, label := range sourceLabels {
+		if slices.Contains(s.removedLabels, label) {
+			continue
 		}
-	}
 
-	return targetIssue, nil
-}
+		if targetLabel, ok := s.labelRenames[label]; ok {
+			label = targetLabel
+		}
 
-func (s *migrator) canSetAssignee(sourceIssue *jira.Issue) bool {
-	if sourceIssue.Fields.Assignee == nil {
-		return false
+		if label != "" && !slices.Contains(targetLabels, label) {
+			targetLabels = append(targetLabels, label)
+		}
 	}
-	user, _, _ := s.targetClient.User.GetByAccountID(sourceIssue.Fields.Assignee.AccountID) //TODO Could be cached for optimization
-	return user != nil && user.Active
-}
 
-func (s *migrator) canSetReporter(sourceIssue *jira.Issue) bool {
-	if sourceIssue.Fields.Reporter == nil {
-		return false
+	for _, label := range s.additionalLabels {
+		if !slices.Contains(targetLabels, label) {
+			targetLabels = append(targetLabels, label)
+		}
 	}
-	user, _, _ := s.targetClient.User.GetByAccountID(sourceIssue.Fields.Reporter.AccountID) //TODO Could be cached for optimization
-	return user != nil && user.Active
+
+	return targetLabels
 }
 
 func (s *migrator) getSourceUrl(sourceIssue *jira.Issue) string {
@@ -235,31 +370,3 @@ func (s *migrator) getSourceUrl(sourceIssue *jira.Issue) string {
 	url, _ := url.JoinPath(sourceBaseUrl.String(), "/browse", sourceIssue.Key)
 	return url
 }
-
-func (s *migrator) findTargetIssueBySummaryAndDescription(summary string, descriptionKeywords ...string) (*jira.Issue, error) {
-	summaryTerm := internal.SanitizeTermForJQL(summary)
-
-	descriptionTerms := ""
-	if len(descriptionKeywords) > 0 {
-		for _, keyword := range descriptionKeywords {
-			descriptionTerms += ` AND description ~ "` + keyword + `"`
-		}
-	}
-
-	searchResult, response, err := s.targetClient.Issue.
-		Search(fmt.Sprintf(`project = %s AND summary ~ "%s"%s`, s.targetProjectKey, summaryTerm, descriptionTerms),
-			&jira.SearchOptions{MaxResults: maxResultsPerSearch, Fields: []string{"key", "summary"}})
-	if err != nil {
-		return nil, parseResponseError("issueExists", response, err)
-	}
-
-	defer response.Body.Close()
-
-	for _, existingIssue := range searchResult {
-		if strings.EqualFold(existingIssue.Fields.Summary, summary) {
-			return &existingIssue, nil
-		}
-	}
-
-	return nil, nil
-}
@@ -0,0 +1,685 @@
+package migration
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	StepCreate = "create"
+	// StepDescription is the description document of an issue migrated with ADF, set once the issue is created
+	StepDescription = "description"
+	StepSprint      = "sprint"
+	StepComments    = "comments"
+	StepWorklogs    = "worklogs"
+	StepAttachments = "attachments"
+	StepRemoteLink  = "remote-link"
+	StepLinks       = "links"
+	StepStatus      = "status"
+	StepHistory     = "history"
+	// StepFields is the update of the fields of an issue already migrated, made by sync runs
+	StepFields = "fields"
+	// StepReferences is the rewrite of the issue references, whose items are the issue and its migrated comments
+	// mapped to the run that must rewrite them, or to done once rewritten
+	StepReferences = "references"
+)
+
+var migrationSteps = []string{StepCreate, StepDescription, StepSprint, StepComments, StepWorklogs, StepAttachments, StepRemoteLink, StepLinks, StepStatus, StepHistory}
+
+// MigrationSteps returns the steps of an issue migration, in execution order
+func MigrationSteps() []string {
+	return append([]string(nil), migrationSteps...)
+}
+
+const (
+	StepStatusDone   = "done"
+	StepStatusFailed = "failed"
+	// StepStatusSkipped is reported for steps done by an earlier run, it is never recorded in the ledger
+	StepStatusSkipped = "skipped"
+)
+
+// LedgerEntry is the migration state of a single source issue
+type LedgerEntry struct {
+	SourceKey string            `json:"sourceKey"`
+	TargetKey string            `json:"targetKey,omitempty"`
+	RunID     string            `json:"runId,omitempty"`
+	Steps     map[string]string `json:"steps,omitempty"`
+	// Items maps the source items (comments, attachments, links) migrated by a step to their target IDs
+	Items     map[string]map[string]string `json:"items,omitempty"`
+	Deleted   bool                         `json:"deleted,omitempty"`
+	UpdatedAt time.Time                    `json:"updatedAt"`
+}
+
+func (e LedgerEntry) IsStepDone(step string) bool {
+	return e.Steps[step] == StepStatusDone
+}
+
+// IsComplete tells whether every migration step of the issue succeeded
+func (e LedgerEntry) IsComplete() bool {
+	for _, step := range migrationSteps {
+		if !e.IsStepDone(step) {
+			return false
+		}
+	}
+
+	return true
+}
+
+const (
+	RunMigrate = "migrate"
+	RunMirror  = "mirror"
+	RunWebhook = "webhook"
+)
+
+// LedgerRun is the checkpoint of a migration run
+type LedgerRun struct {
+	ID               string     `json:"id"`
+	JQL              string     `json:"jql"`
+	SourceProjectKey string     `json:"sourceProjectKey"`
+	TargetProjectKey string     `json:"targetProjectKey"`
+	StartAt          int        `json:"startAt"`
+	StartedAt        time.Time  `json:"startedAt"`
+	FinishedAt       *time.Time `json:"finishedAt,omitempty"`
+	// Kind tells how the run selected its issues, by query (migrate), by polling (mirror) or by webhook events
+	Kind string `json:"kind,omitempty"`
+	// Query is the query the run was started with, before sync runs restrict it to the issues updated since
+	Query string `json:"query,omitempty"`
+	// Since is set by sync runs, which only migrate the issues updated after it
+	Since *time.Time `json:"since,omitempty"`
+	// HighWaterMark is set by mirror runs, every issue updated up to it was mirrored
+	HighWaterMark *time.Time `json:"highWaterMark,omitempty"`
+	// Failed are the source keys of the issues the run could not migrate, retried when the run is resumed
+	Failed []string `json:"failed,omitempty"`
+}
+
+func (r LedgerRun) IsFinished() bool {
+	return r.FinishedAt != nil
+}
+
+// isEventDriven tells whether the run migrated issues as they changed, which may have missed some of them, instead
+// of every issue of its query. Runs recorded before the kind was kept are told apart by what they recorded.
+func (r LedgerRun) isEventDriven() bool {
+	switch r.Kind {
+	case RunMirror, RunWebhook:
+		return true
+	case "":
+		return r.HighWaterMark != nil || r.JQL == RunWebhook
+	default:
+		return false
+	}
+}
+
+// hasQuery tells whether the run was started with the query, runs recorded before the query was kept are matched by
+// their JQL
+func (r LedgerRun) hasQuery(query string) bool {
+	if r.Query == "" {
+		return r.JQL == query
+	}
+
+	return r.Query == query
+}
+
+const (
+	ObjectIssue      = "issue"
+	ObjectSprint     = "sprint"
+	ObjectRemoteLink = "remote-link"
+	ObjectIssueLink  = "issue-link"
+)
+
+// LedgerObject is something a run created on the target, recorded so the run can be rolled back
+type LedgerObject struct {
+	RunID string `json:"runId"`
+	Kind  string `json:"kind"`
+	ID    string `json:"id"`
+	// IssueKey is the target issue the object was created in, or the inward issue of an issue link
+	IssueKey  string    `json:"issueKey,omitempty"`
+	SourceKey string    `json:"sourceKey,omitempty"`
+	ParentKey string    `json:"parentKey,omitempty"`
+	LinkType  string    `json:"linkType,omitempty"`
+	LinkedKey string    `json:"linkedKey,omitempty"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (o LedgerObject) String() string {
+	switch o.Kind {
+	case ObjectIssue:
+		return fmt.Sprintf("issue %s (from %s)", o.ID, o.SourceKey)
+	case ObjectRemoteLink:
+		return fmt.Sprintf("remote link %s of %s", o.ID, o.IssueKey)
+	case ObjectIssueLink:
+		return fmt.Sprintf("link %s %s %s", o.IssueKey, o.LinkType, o.LinkedKey)
+	default:
+		return fmt.Sprintf("%s %s", o.Kind, o.ID)
+	}
+}
+
+// LedgerTarget is the target issue a source issue was migrated to, appended on its own instead of the whole entry of
+// the issue
+type LedgerTarget struct {
+	SourceKey string    `json:"sourceKey"`
+	TargetKey string    `json:"targetKey"`
+	RunID     string    `json:"runId,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// LedgerStep is the status of a migration step of a source issue, appended on its own instead of the whole entry of
+// the issue
+type LedgerStep struct {
+	SourceKey string    `json:"sourceKey"`
+	Step      string    `json:"step"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// LedgerItem is a source item migrated by a step, appended on its own instead of the whole entry of the issue
+type LedgerItem struct {
+	SourceKey    string    `json:"sourceKey"`
+	Step         string    `json:"step"`
+	SourceItemID string    `json:"sourceItemId"`
+	TargetItemID string    `json:"targetItemId,omitempty"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+type ledgerLine struct {
+	*LedgerEntry
+	Run    *LedgerRun    `json:"run,omitempty"`
+	Object *LedgerObject `json:"object,omitempty"`
+	Target *LedgerTarget `json:"target,omitempty"`
+	Step   *LedgerStep   `json:"step,omitempty"`
+	Item   *LedgerItem   `json:"item,omitempty"`
+}
+
+// Ledger is the authority on which source issues were already migrated and where to.
+// Every change is appended to the ledger file as a JSON line of its own, the target, each step and each item of a
+// source issue. A whole entry line, written by Forget and by earlier versions, replaces what was loaded before it.
+// The changes appended after it are added to it.
+type Ledger struct {
+	mutex   sync.Mutex
+	file    *os.File
+	entries map[string]*LedgerEntry
+	runs    map[string]*LedgerRun
+	objects map[string]*LedgerObject
+	// objectOrder keeps the object keys in creation order
+	objectOrder []string
+}
+
+// OpenLedger loads the ledger stored at path, creating it if needed.
+// An empty path gives an in-memory ledger that lasts only for the current process.
+func OpenLedger(path string) (*Ledger, error) {
+	l := &Ledger{entries: map[string]*LedgerEntry{}, runs: map[string]*LedgerRun{}, objects: map[string]*LedgerObject{}}
+
+	if path == "" {
+		return l, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open ledger")
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		record := ledgerLine{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			file.Close()
+			return nil, errors.Wrapf(err, "could not read ledger %s at line %d", path, line)
+		}
+
+		if record.Run != nil {
+			l.runs[record.Run.ID] = record.Run
+			continue
+		}
+
+		if record.Object != nil {
+			l.loadObject(record.Object)
+			continue
+		}
+
+		if record.Target != nil {
+			l.loadTarget(record.Target)
+			continue
+		}
+
+		if record.Step != nil {
+			l.loadStep(record.Step)
+			continue
+		}
+
+		if record.Item != nil {
+			l.loadItem(record.Item)
+			continue
+		}
+
+		entry := record.LedgerEntry
+		if entry == nil {
+			continue
+		}
+
+		if entry.Deleted {
+			delete(l.entries, entry.SourceKey)
+			continue
+		}
+
+		l.entries[entry.SourceKey] = entry
+	}
+
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "could not read ledger %s", path)
+	}
+
+	l.file = file
+
+	return l, nil
+}
+
+func (l *Ledger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+
+	return l.file.Close()
+}
+
+// Get returns a copy of the entry of the source issue
+func (l *Ledger) Get(sourceKey string) (LedgerEntry, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry, ok := l.entries[sourceKey]
+	if !ok {
+		return LedgerEntry{}, false
+	}
+
+	return entry.clone(), true
+}
+
+// TargetKey returns the target issue key the source issue was migrated to, if any
+func (l *Ledger) TargetKey(sourceKey string) (string, bool) {
+	entry, ok := l.Get(sourceKey)
+	if !ok || entry.TargetKey == "" {
+		return "", false
+	}
+
+	return entry.TargetKey, true
+}
+
+// Entries returns a copy of all entries sorted by source key
+func (l *Ledger) Entries() []LedgerEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entries := make([]LedgerEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		entries = append(entries, entry.clone())
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].SourceKey < entries[j].SourceKey
+	})
+
+	return entries
+}
+
+func (l *Ledger) SetTarget(sourceKey, targetKey, runID string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	target := &LedgerTarget{SourceKey: sourceKey, TargetKey: targetKey, RunID: runID, UpdatedAt: time.Now().UTC()}
+	l.loadTarget(target)
+
+	return l.write(ledgerLine{Target: target})
+}
+
+func (l *Ledger) SetStep(sourceKey, step string, err error) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	status := StepStatusDone
+	if err != nil {
+		status = StepStatusFailed
+	}
+
+	ledgerStep := &LedgerStep{SourceKey: sourceKey, Step: step, Status: status, UpdatedAt: time.Now().UTC()}
+	l.loadStep(ledgerStep)
+
+	return l.write(ledgerLine{Step: ledgerStep})
+}
+
+func (l *Ledger) loadTarget(target *LedgerTarget) {
+	entry := l.entry(target.SourceKey)
+	entry.TargetKey = target.TargetKey
+	entry.RunID = target.RunID
+	entry.UpdatedAt = target.UpdatedAt
+}
+
+func (l *Ledger) loadStep(step *LedgerStep) {
+	entry := l.entry(step.SourceKey)
+	if entry.Steps == nil {
+		entry.Steps = map[string]string{}
+	}
+
+	entry.Steps[step.Step] = step.Status
+	entry.UpdatedAt = step.UpdatedAt
+}
+
+// IsItemDone tells whether a source item of a step was already migrated
+func (l *Ledger) IsItemDone(sourceKey, step, sourceItemID string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry, ok := l.entries[sourceKey]
+	if !ok {
+		return false
+	}
+
+	_, done := entry.Items[step][sourceItemID]
+	return done
+}
+
+// SetItem records that a source item of a step was migrated to the given target item
+func (l *Ledger) SetItem(sourceKey, step, sourceItemID, targetItemID string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	item := &LedgerItem{SourceKey: sourceKey, Step: step, SourceItemID: sourceItemID, TargetItemID: targetItemID, UpdatedAt: time.Now().UTC()}
+	l.loadItem(item)
+
+	return l.write(ledgerLine{Item: item})
+}
+
+func (l *Ledger) loadItem(item *LedgerItem) {
+	entry := l.entry(item.SourceKey)
+	if entry.Items == nil {
+		entry.Items = map[string]map[string]string{}
+	}
+
+	if entry.Items[item.Step] == nil {
+		entry.Items[item.Step] = map[string]string{}
+	}
+
+	entry.Items[item.Step][item.SourceItemID] = item.TargetItemID
+	entry.UpdatedAt = item.UpdatedAt
+}
+
+// EntriesWithItemTarget returns a copy of the entries with an item of the step migrated to the given target item,
+// sorted by source key
+func (l *Ledger) EntriesWithItemTarget(step, targetItemID string) []LedgerEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var entries []LedgerEntry
+	for _, entry := range l.entries {
+		for _, itemTargetID := range entry.Items[step] {
+			if itemTargetID == targetItemID {
+				entries = append(entries, entry.clone())
+				break
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].SourceKey < entries[j].SourceKey
+	})
+
+	return entries
+}
+
+// Forget removes the source issue from the ledger, so it can be migrated again
+func (l *Ledger) Forget(sourceKey string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.entries, sourceKey)
+
+	return l.write(ledgerLine{LedgerEntry: &LedgerEntry{SourceKey: sourceKey, Deleted: true, UpdatedAt: time.Now().UTC()}})
+}
+
+// Runs returns a copy of all runs sorted by start time
+func (l *Ledger) Runs() []LedgerRun {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	runs := make([]LedgerRun, 0, len(l.runs))
+	for _, run := range l.runs {
+		runs = append(runs, *run)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.Before(runs[j].StartedAt)
+	})
+
+	return runs
+}
+
+// LastUnfinishedRun returns the most recent run of the query between the projects that did not finish
+func (l *Ledger) LastUnfinishedRun(jql, sourceProjectKey, targetProjectKey string) (LedgerRun, bool) {
+	runs := l.Runs()
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if run.JQL == jql && run.SourceProjectKey == sourceProjectKey && run.TargetProjectKey == targetProjectKey {
+			return run, !run.IsFinished()
+		}
+	}
+
+	return LedgerRun{}, false
+}
+
+// LastSuccessfulRun returns the most recent migrate run of the query between the projects that finished without
+// failed issues. Runs with failed issues are passed over, so the issues they failed are migrated again, and so are
+// runs of other queries and mirror or webhook runs, which may not have selected every issue of this one.
+func (l *Ledger) LastSuccessfulRun(query, sourceProjectKey, targetProjectKey string) (LedgerRun, bool) {
+	runs := l.Runs()
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if run.isEventDriven() || !run.hasQuery(query) {
+			continue
+		}
+
+		if run.SourceProjectKey == sourceProjectKey && run.TargetProjectKey == targetProjectKey && run.IsFinished() && len(run.Failed) == 0 {
+			return run, true
+		}
+	}
+
+	return LedgerRun{}, false
+}
+
+// LastHighWaterMark returns the high-water mark of the most recent mirror run of the query between the projects
+func (l *Ledger) LastHighWaterMark(query, sourceProjectKey, targetProjectKey string) (time.Time, bool) {
+	runs := l.Runs()
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if run.hasQuery(query) && run.SourceProjectKey == sourceProjectKey && run.TargetProjectKey == targetProjectKey && run.HighWaterMark != nil {
+			return *run.HighWaterMark, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func (l *Ledger) StartRun(run LedgerRun) error {
+	return l.updateRun(run.ID, func(existingRun *LedgerRun) {
+		*existingRun = run
+	})
+}
+
+// SetRunCheckpoint records the search offset from which the run must continue when resumed
+func (l *Ledger) SetRunCheckpoint(runID string, startAt int) error {
+	return l.updateRun(runID, func(run *LedgerRun) {
+		run.StartAt = startAt
+	})
+}
+
+// SetRunIssueFailed records whether the run failed to migrate the source issue, writing the run only when it changes
+func (l *Ledger) SetRunIssueFailed(runID, sourceKey string, failed bool) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	run, ok := l.runs[runID]
+	if !ok {
+		run = &LedgerRun{ID: runID}
+		l.runs[runID] = run
+	}
+
+	index := slices.Index(run.Failed, sourceKey)
+	switch {
+	case failed && index < 0:
+		run.Failed = append(run.Failed, sourceKey)
+	case !failed && index >= 0:
+		run.Failed = slices.Delete(run.Failed, index, index+1)
+	default:
+		return nil
+	}
+
+	return l.write(ledgerLine{Run: run})
+}
+
+func (l *Ledger) SetRunHighWaterMark(runID string, highWaterMark time.Time) error {
+	return l.updateRun(runID, func(run *LedgerRun) {
+		run.HighWaterMark = &highWaterMark
+	})
+}
+
+func (l *Ledger) FinishRun(runID string) error {
+	return l.updateRun(runID, func(run *LedgerRun) {
+		finishedAt := time.Now().UTC()
+		run.FinishedAt = &finishedAt
+	})
+}
+
+// AddObject records something the run created on the target
+func (l *Ledger) AddObject(object LedgerObject) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	object.CreatedAt = time.Now().UTC()
+	l.loadObject(&object)
+
+	return l.write(ledgerLine{Object: &object})
+}
+
+// RemoveObject records that an object created by a run no longer exists on the target
+func (l *Ledger) RemoveObject(object LedgerObject) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	object.Deleted = true
+	l.loadObject(&object)
+
+	return l.write(ledgerLine{Object: &object})
+}
+
+// RunObjects returns the objects created by the run, in creation order
+func (l *Ledger) RunObjects(runID string) []LedgerObject {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var objects []LedgerObject
+	seen := map[string]bool{}
+	for _, key := range l.objectOrder {
+		object, ok := l.objects[key]
+		if !ok || seen[key] || object.RunID != runID {
+			continue
+		}
+
+		seen[key] = true
+		objects = append(objects, *object)
+	}
+
+	return objects
+}
+
+func (l *Ledger) loadObject(object *LedgerObject) {
+	key := object.Kind + "/" + object.ID
+
+	if object.Deleted {
+		delete(l.objects, key)
+		return
+	}
+
+	if _, ok := l.objects[key]; !ok {
+		l.objectOrder = append(l.objectOrder, key)
+	}
+
+	l.objects[key] = object
+}
+
+func (l *Ledger) updateRun(runID string, change func(run *LedgerRun)) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	run, ok := l.runs[runID]
+	if !ok {
+		run = &LedgerRun{ID: runID}
+		l.runs[runID] = run
+	}
+
+	change(run)
+
+	return l.write(ledgerLine{Run: run})
+}
+
+// entry returns the entry of the source issue, adding it when missing
+func (l *Ledger) entry(sourceKey string) *LedgerEntry {
+	entry, ok := l.entries[sourceKey]
+	if !ok {
+		entry = &LedgerEntry{SourceKey: sourceKey}
+		l.entries[sourceKey] = entry
+	}
+
+	return entry
+}
+
+func (l *Ledger) write(record ledgerLine) error {
+	if l.file == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "could not write ledger")
+	}
+
+	return l.file.Sync()
+}
+
+func (e *LedgerEntry) clone() LedgerEntry {
+	clone := *e
+	if e.Steps != nil {
+		clone.Steps = make(map[string]string, len(e.Steps))
+		for step, status := range e.Steps {
+			clone.Steps[step] = status
+		}
+	}
+
+	if e.Items != nil {
+		clone.Items = make(map[string]map[string]string, len(e.Items))
+		for step, items := range e.Items {
+			clone.Items[step] = make(map[string]string, len(items))
+			for sourceItemID, targetItemID := range items {
+				clone.Items[step][sourceItemID] = targetItemID
+			}
+		}
+	}
+
+	return clone
+}
@@ -0,0 +1,92 @@
+package migration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestLastSuccessfulRunMatchesTheQueryOfMigrateRuns(t *testing.T) {
+	ledger, err := OpenLedger("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		fullQuery   = "project = SRC AND () ORDER BY key ASC"
+		narrowQuery = "project = SRC AND (labels = urgent) ORDER BY key ASC"
+	)
+
+	startedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, run := range []LedgerRun{
+		{ID: "full", JQL: fullQuery},
+		{ID: "narrow", JQL: narrowQuery + " AND updated", Query: narrowQuery},
+		{ID: "mirror", Kind: RunMirror, JQL: fullQuery, Query: fullQuery},
+		{ID: "webhook", Kind: RunWebhook, JQL: RunWebhook},
+	} {
+		run.SourceProjectKey, run.TargetProjectKey = "SRC", "TGT"
+		run.StartedAt = startedAt.Add(time.Duration(i) * time.Hour)
+
+		if err := ledger.StartRun(run); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ledger.FinishRun(run.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for query, want := range map[string]string{fullQuery: "full", narrowQuery: "narrow"} {
+		run, ok := ledger.LastSuccessfulRun(query, "SRC", "TGT")
+		if !ok || run.ID != want {
+			t.Errorf("got run %q for %q, want %q", run.ID, query, want)
+		}
+	}
+}
+
+func TestLedgerAppendsOnlyTheChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.ledger")
+
+	ledger, err := OpenLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, change := range []func() error{
+		func() error { return ledger.SetTarget("SRC-1", "TGT-1", "run") },
+		func() error { return ledger.SetItem("SRC-1", StepComments, "1", "2") },
+		func() error { return ledger.SetStep("SRC-1", StepComments, nil) },
+		func() error { return ledger.SetStep("SRC-1", StepLinks, errors.New("failed")) },
+	} {
+		if err := change(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ledger.Close()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(content), `"items"`) {
+		t.Errorf("got the items written again with the entry, want only the changes:\n%s", content)
+	}
+
+	ledger, err = OpenLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+
+	entry, _ := ledger.Get("SRC-1")
+	if entry.TargetKey != "TGT-1" || entry.RunID != "run" || entry.Items[StepComments]["1"] != "2" ||
+		entry.Steps[StepComments] != StepStatusDone || entry.Steps[StepLinks] != StepStatusFailed {
+		t.Errorf("got %+v after reopening the ledger", entry)
+	}
+}
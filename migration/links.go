@@ -2,19 +2,47 @@ package migration
 
 import (
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/natenho/go-jira"
 	"github.com/pkg/errors"
 )
 
+const originalIssueLinkPrefix = "Original Issue - "
+
 func (s *migrator) linkToOriginalIssue(sourceIssue, targetIssue *jira.Issue) error {
-	return s.linkRemoteIssue(sourceIssue, targetIssue, "Original Issue - ")
+	return s.linkRemoteIssue(sourceIssue, targetIssue, originalIssueLinkPrefix)
+}
+
+// getOriginalIssueKey returns the source issue key a target issue was migrated from, based on its remote links
+func (s *migrator) getOriginalIssueKey(remoteLinks []jira.RemoteLink) (string, bool) {
+	sourceBaseUrl := s.sourceClient.GetBaseURL()
+	sourceBrowseUrl, _ := url.JoinPath(sourceBaseUrl.String(), "/browse/")
+
+	for _, remoteLink := range remoteLinks {
+		if remoteLink.Object == nil || !strings.HasPrefix(remoteLink.Object.Title, originalIssueLinkPrefix) {
+			continue
+		}
+
+		if !strings.HasPrefix(remoteLink.Object.URL, sourceBrowseUrl) {
+			continue
+		}
+
+		sourceKey := strings.TrimPrefix(remoteLink.Object.URL, sourceBrowseUrl)
+		if sourceKey != "" && !strings.Contains(sourceKey, "/") {
+			return sourceKey, true
+		}
+	}
+
+	return "", false
 }
 
 func (s *migrator) linkRemoteIssue(remoteIssue, targetIssue *jira.Issue, prefix string) error {
 	url := s.getSourceUrl(remoteIssue)
-	_, response, err := s.targetClient.Issue.AddRemoteLink(targetIssue.Key,
+	remoteLink, response, err := s.targetClient.Issue.AddRemoteLink(targetIssue.Key,
 		&jira.RemoteLink{Object: &jira.RemoteLinkObject{
 			URL:   url,
 			Title: fmt.Sprintf("%s%s", prefix, url),
@@ -23,7 +51,7 @@ func (s *migrator) linkRemoteIssue(remoteIssue, targetIssue *jira.Issue, prefix
 		return parseResponseError("linkRemoteIssue", response, err)
 	}
 
-	return nil
+	return s.recordObject(LedgerObject{Kind: ObjectRemoteLink, ID: strconv.Itoa(remoteLink.ID), IssueKey: targetIssue.Key})
 }
 
 func (s *migrator) migrateLinks(sourceIssue, targetIssue *jira.Issue) chan error {
@@ -33,10 +61,22 @@ func (s *migrator) migrateLinks(sourceIssue, targetIssue *jira.Issue) chan error
 	defer close(errChan)
 
 	for _, item := range sourceIssue.Fields.IssueLinks {
+		if s.ledger.IsItemDone(sourceIssue.Key, StepLinks, item.ID) {
+			continue
+		}
+
 		wg.Add(1)
 		go func(item *jira.IssueLink) {
-			errChan <- s.migrateLink(item, targetIssue)
-			wg.Done()
+			defer wg.Done()
+
+			created, err := s.migrateLink(item, targetIssue)
+			if err != nil || !created {
+				// Links to issues not migrated yet are tried again by later runs
+				errChan <- err
+				return
+			}
+
+			errChan <- s.ledger.SetItem(sourceIssue.Key, StepLinks, item.ID, "")
 		}(item)
 	}
 
@@ -44,70 +84,25 @@ func (s *migrator) migrateLinks(sourceIssue, targetIssue *jira.Issue) chan error
 	return errChan
 }
 
-func (s *migrator) migrateLink(link *jira.IssueLink, targetIssue *jira.Issue) error {
-	var targetInwardIssue, targetOutwardIssue *jira.Issue
-
-	if link.InwardIssue == nil {
-		targetInwardIssue = targetIssue
-	} else {
-		sourceInwardIssue, err := s.getSourceIssueByKey(link.InwardIssue.Key)
-		if err != nil {
-			return err
-		}
-
-		if err := s.remoteLinkToRelatedIssue(sourceInwardIssue, targetIssue, link); err != nil {
-			return errors.Errorf("could not remote link to %s: %#v", sourceInwardIssue.Key, err)
-		}
-
-		targetInwardIssue, err = s.findTargetIssueBySummaryAndDescription(sourceInwardIssue.Fields.Summary)
-		if err != nil {
-			return err
-		}
-
-		if targetInwardIssue == nil && s.canMigrateLinkedIssue(sourceInwardIssue) {
-			result := s.migrateIssue(sourceInwardIssue.Key)
-			if !result.HasTargetIssue() {
-				return errors.Errorf("could not create link: %s could not be created on target: %#v", sourceInwardIssue.Key, result.Errors)
-			}
-
-			targetInwardIssue = &jira.Issue{Key: result.TargetKey}
-		}
+// migrateLink creates the link on the target, telling whether it was created. It is not when one of the issues
+// could not be migrated.
+func (s *migrator) migrateLink(link *jira.IssueLink, targetIssue *jira.Issue) (bool, error) {
+	targetInwardIssue, err := s.resolveLinkedIssue(link.InwardIssue, targetIssue, link)
+	if err != nil {
+		return false, err
 	}
 
-	if link.OutwardIssue == nil {
-		targetOutwardIssue = targetIssue
-	} else {
-		sourceOutwardIssue, err := s.getSourceIssueByKey(link.OutwardIssue.Key)
-		if err != nil {
-			return err
-		}
-
-		if err := s.remoteLinkToRelatedIssue(sourceOutwardIssue, targetIssue, link); err != nil {
-			return errors.Errorf("could not remote link to %s: %#v", sourceOutwardIssue.Key, err)
-		}
-
-		if targetOutwardIssue == nil && s.canMigrateLinkedIssue(sourceOutwardIssue) {
-			targetOutwardIssue, err = s.findTargetIssueBySummaryAndDescription(sourceOutwardIssue.Fields.Summary)
-			if err != nil {
-				return err
-			}
-
-			if targetOutwardIssue == nil {
-				result := s.migrateIssue(sourceOutwardIssue.Key)
-				if !result.HasTargetIssue() {
-					return errors.Errorf("could not create link: %s could not be created on target: %#v", sourceOutwardIssue.Key, result.Errors)
-				}
-				targetOutwardIssue = &jira.Issue{Key: result.TargetKey}
-			}
-		}
+	targetOutwardIssue, err := s.resolveLinkedIssue(link.OutwardIssue, targetIssue, link)
+	if err != nil {
+		return false, err
 	}
 
 	if targetInwardIssue == nil || targetOutwardIssue == nil {
-		return nil
+		return false, nil
 	}
 
 	if targetInwardIssue.Key == targetOutwardIssue.Key {
-		return nil
+		return false, nil
 	}
 
 	targetLink := &jira.IssueLink{
@@ -117,10 +112,47 @@ func (s *migrator) migrateLink(link *jira.IssueLink, targetIssue *jira.Issue) er
 	}
 	response, err := s.targetClient.Issue.AddLink(targetLink)
 	if err != nil {
-		return parseResponseError("AddLink", response, err)
+		return false, parseResponseError("AddLink", response, err)
+	}
+
+	return true, s.recordObject(LedgerObject{
+		Kind:      ObjectIssueLink,
+		ID:        fmt.Sprintf("%s %s %s", targetInwardIssue.Key, link.Type.Name, targetOutwardIssue.Key),
+		IssueKey:  targetInwardIssue.Key,
+		LinkType:  link.Type.Name,
+		LinkedKey: targetOutwardIssue.Key,
+	})
+}
+
+// resolveLinkedIssue returns the target counterpart of one side of a source link, migrating it when needed
+func (s *migrator) resolveLinkedIssue(linkedIssue *jira.Issue, targetIssue *jira.Issue, link *jira.IssueLink) (*jira.Issue, error) {
+	if linkedIssue == nil {
+		return targetIssue, nil
+	}
+
+	sourceLinkedIssue, err := s.getSourceIssueByKey(linkedIssue.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.remoteLinkToRelatedIssue(sourceLinkedIssue, targetIssue, link); err != nil {
+		return nil, errors.Errorf("could not remote link to %s: %#v", sourceLinkedIssue.Key, err)
 	}
 
-	return nil
+	if targetKey, ok := s.ledger.TargetKey(sourceLinkedIssue.Key); ok {
+		return &jira.Issue{Key: targetKey}, nil
+	}
+
+	if !s.canMigrateLinkedIssue(sourceLinkedIssue) {
+		return nil, nil
+	}
+
+	result := s.migrateIssue(sourceLinkedIssue.Key)
+	if !result.HasTargetIssue() {
+		return nil, errors.Errorf("could not create link: %s could not be created on target: %v", sourceLinkedIssue.Key, result.Errors)
+	}
+
+	return &jira.Issue{Key: result.TargetKey}, nil
 }
 
 func (s *migrator) canMigrateLinkedIssue(linkedIssue *jira.Issue) bool {
@@ -143,14 +175,18 @@ func (s *migrator) remoteLinkToRelatedIssue(relatedIssue *jira.Issue, targetIssu
 		linkType = link.Type.Inward
 	}
 
-	_, response, err := s.targetClient.Issue.AddRemoteLink(targetIssue.ID,
+	// The global ID updates the remote link instead of adding another one when the link is tried again
+	title := fmt.Sprintf("%s %s", linkType, url)
+	remoteLink, response, err := s.targetClient.Issue.AddRemoteLink(targetIssue.ID,
 		&jira.RemoteLink{
+			GlobalID: title,
 			Object: &jira.RemoteLinkObject{
 				URL:   url,
-				Title: fmt.Sprintf("%s %s", linkType, url),
+				Title: title,
 			}})
 	if err != nil {
 		return parseResponseError("AddRemoteLink", response, err)
 	}
-	return nil
+
+	return s.recordObject(LedgerObject{Kind: ObjectRemoteLink, ID: strconv.Itoa(remoteLink.ID), IssueKey: targetIssue.Key})
 }
@@ -1,12 +1,9 @@
 package migration
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/url"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -20,35 +17,78 @@ type Result struct {
 	SourceKey     string
 	SourceSummary string
 	TargetKey     string
+	IssueType     string
+	Steps         []StepResult
+	Comments      []CommentResult
+	Duration      time.Duration
 	Errors        []error
 }
 
+// StepResult is the outcome of a migration step of an issue, with the items it migrated out of the source ones
+type StepResult struct {
+	Name     string   `json:"name"`
+	Status   string   `json:"status"`
+	Migrated int      `json:"migrated,omitempty"`
+	Total    int      `json:"total,omitempty"`
+	Bytes    int64    `json:"bytes,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
 const maxResultsPerSearch = 100
-const rateLimitRetryInterval = time.Second * 30
 
 func (r Result) String() string {
 	result := "OK"
 	if len(r.Errors) > 0 {
-		result = fmt.Sprintf("%#v", r.Errors)
+		result = fmt.Sprintf("%v", r.Errors)
 	}
 
 	return fmt.Sprintf("%s;%s;%s;%s", r.SourceKey, r.SourceSummary, r.TargetKey, result)
 }
 
+// Step returns the outcome of the step, which is empty when the step did not run
+func (r Result) Step(name string) StepResult {
+	for _, step := range r.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+
+	return StepResult{Name: name}
+}
+
 func (r Result) HasTargetIssue() bool {
 	return r.TargetKey != ""
 }
 
-func (r Result) HasTooManyRequestsError() bool {
-	return strings.Contains(strings.ToLower(r.String()), "too many") //TODO Improve this error detection
+// HasError tells whether any error of the result is of the given kind
+func (r Result) HasError(kind error) bool {
+	for _, err := range r.Errors {
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+
+	return false
 }
 
 type Migrator interface {
 	Execute(jql string) (chan Result, error)
+	Plan(jql string) (*Plan, error)
+	Verify(jql string) (chan Verification, error)
+	Mirror(jql string, interval time.Duration) (*Mirror, error)
+	ReceiveWebhooks() (*WebhookReceiver, error)
+	Rollback(runID string, dryRun bool) ([]RollbackAction, error)
+	RebuildLedger() (int, error)
+	UnmappedUsers() []UnmappedUser
 }
 
 type migrator struct {
+	// currentUser is the target user migrating the issues, sourceUser the one reading them
 	currentUser *jira.User
+	sourceUser  *jira.User
+
+	sourceInstance *instance
+	targetInstance *instance
 
 	additionalLabels []string
 	customFields     []string
@@ -59,6 +99,27 @@ type migrator struct {
 	sourceProjectKey string
 	targetProjectKey string
 
+	fieldMappings  map[string][]string
+	fieldValues    map[string]interface{}
+	statusMappings map[string]string
+	// visibilityMappings are the target roles and groups of the source ones restricting comments
+	visibilityMappings map[string]string
+	// originalFields are the target fields filled with original values of the source issues, by value
+	originalFields map[string]string
+	userMappings   map[string]string
+	userMatching   []string
+	users          *userDirectory
+
+	rewriteReferences    bool
+	referenceAuditPath   string
+	referencePattern     *regexp.Regexp
+	referencePatternOnce sync.Once
+
+	attachmentPattern     *regexp.Regexp
+	attachmentPatternOnce sync.Once
+	labelRenames          map[string]string
+	removedLabels         []string
+
 	sourceTargetCustomFieldMap map[string][]jira.Field
 	sourceFieldPerIssueType    map[string][]jira.Field
 	targetFieldPerIssueType    map[string][]jira.Field
@@ -68,9 +129,22 @@ type migrator struct {
 
 	syncRoot sync.Map
 
-	workerPoolSize int
-	importSprints  bool
-	deleteOnError  bool
+	ledger     *Ledger
+	runID      string
+	checkpoint *checkpoint
+
+	workerPoolSize  int
+	adaptiveWorkers bool
+	minWorkers      int
+	maxWorkers      int
+	transport       *Transport
+	importSprints   bool
+	deleteOnError   bool
+	resume          bool
+	sync            bool
+	verifyChecksums bool
+	adf             bool
+	historyFormats  []string
 }
 
 type Option func(m *migrator)
@@ -95,12 +169,74 @@ func WithWorkerPoolSize(size int) Option {
 	}
 }
 
+// WithAdaptiveWorkers lets Execute change the number of workers between the limits, following the throughput and throttling
+func WithAdaptiveWorkers(minWorkers, maxWorkers int) Option {
+	return func(m *migrator) {
+		if minWorkers <= 0 || maxWorkers < minWorkers {
+			return
+		}
+
+		m.adaptiveWorkers = true
+		m.minWorkers = minWorkers
+		m.maxWorkers = maxWorkers
+	}
+}
+
+// WithTransport defines the transport pacing the requests of both clients, to be shared by the migrators running
+// at the same time so they do not exceed its rate together
+func WithTransport(transport *Transport) Option {
+	return func(m *migrator) {
+		m.transport = transport
+	}
+}
+
 func WithCustomFields(customFieldNames ...string) Option {
 	return func(m *migrator) {
 		m.customFields = customFieldNames
 	}
 }
 
+// WithFieldMapping migrates the source field to a target field with another name
+func WithFieldMapping(sourceFieldName, targetFieldName string) Option {
+	return func(m *migrator) {
+		m.fieldMappings[strings.ToLower(sourceFieldName)] = append(m.fieldMappings[strings.ToLower(sourceFieldName)], targetFieldName)
+	}
+}
+
+// WithFieldValue sets a fixed value on the target field whenever the source field has any value
+func WithFieldValue(targetFieldName string, value interface{}) Option {
+	return func(m *migrator) {
+		m.fieldValues[targetFieldName] = value
+	}
+}
+
+// WithStatusMapping transitions issues in the source status to the target status instead of the first one of the same category
+func WithStatusMapping(sourceStatusName, targetStatusName string) Option {
+	return func(m *migrator) {
+		m.statusMappings[strings.ToLower(sourceStatusName)] = targetStatusName
+	}
+}
+
+// WithUserMapping sets the target user of a source user, by account ID on Cloud and by user name on Server
+func WithUserMapping(sourceUserID, targetUserID string) Option {
+	return func(m *migrator) {
+		m.userMappings[sourceUserID] = targetUserID
+	}
+}
+
+func WithLabelRename(sourceLabel, targetLabel string) Option {
+	return func(m *migrator) {
+		m.labelRenames[sourceLabel] = targetLabel
+	}
+}
+
+// WithoutLabels removes the source labels from migrated issues
+func WithoutLabels(labels ...string) Option {
+	return func(m *migrator) {
+		m.removedLabels = append(m.removedLabels, labels...)
+	}
+}
+
 func WithSprints(value bool) Option {
 	return func(m *migrator) {
 		m.importSprints = value
@@ -113,101 +249,159 @@ func WithDeleteOnError(value bool) Option {
 	}
 }
 
-func NewMigrator(sourceUrl, targetUrl, user, apiToken, sourceProjectKey, targetProjectKey string, options ...Option) (Migrator, error) {
-	if _, err := url.Parse(sourceUrl); err != nil || sourceUrl == "" {
-		return nil, errors.New("invalid source url")
+// WithLedger defines the ledger used to decide whether an issue was already migrated, it is required
+func WithLedger(ledger *Ledger) Option {
+	return func(m *migrator) {
+		m.ledger = ledger
 	}
+}
 
-	if _, err := url.Parse(targetUrl); err != nil || targetUrl == "" {
-		return nil, errors.New("invalid target url")
+func WithRunID(runID string) Option {
+	return func(m *migrator) {
+		if runID != "" {
+			m.runID = runID
+		}
 	}
+}
 
-	if user == "" {
-		return nil, errors.New("invalid user")
+// WithResume defines if an unfinished run of the same query should be continued instead of starting over
+func WithResume(value bool) Option {
+	return func(m *migrator) {
+		m.resume = value
+	}
+}
+
+// WithSync defines if only the issues updated since the last run of the same query without failed issues are migrated, updating the ones already migrated
+func WithSync(value bool) Option {
+	return func(m *migrator) {
+		m.sync = value
 	}
+}
 
-	if apiToken == "" {
-		return nil, errors.New("invalid API token")
+// WithChecksums defines if verification downloads attachments to compare their checksums
+func WithChecksums(value bool) Option {
+	return func(m *migrator) {
+		m.verifyChecksums = value
 	}
+}
 
-	transport := jira.BasicAuthTransport{Username: user, Password: apiToken}
+// newRunID identifies a run by its start time and project pair, as the migrators of several projects start together
+func newRunID(sourceProjectKey, targetProjectKey string) string {
+	runID := time.Now().UTC().Format("20060102T150405.000000000Z")
+	if sourceProjectKey != "" && targetProjectKey != "" {
+		runID += "-" + sourceProjectKey + "-" + targetProjectKey
+	}
 
-	sourceClient, err := jira.NewClient(transport.Client(), sourceUrl)
-	if err != nil {
+	return runID
+}
+
+func NewMigrator(source, target Connection, sourceProjectKey, targetProjectKey string, options ...Option) (Migrator, error) {
+	if err := source.validate("source"); err != nil {
 		return nil, err
 	}
 
-	targetClient, err := jira.NewClient(transport.Client(), targetUrl)
-	if err != nil {
+	if err := target.validate("target"); err != nil {
 		return nil, err
 	}
 
 	m := &migrator{
-		sourceClient:               sourceClient,
-		targetClient:               targetClient,
 		sourceProjectKey:           sourceProjectKey,
 		targetProjectKey:           targetProjectKey,
 		sourceTargetSprintMap:      map[int]*jira.Sprint{},
 		targetFieldPerIssueType:    map[string][]jira.Field{},
 		sourceTargetCustomFieldMap: map[string][]jira.Field{},
+		fieldMappings:              map[string][]string{},
+		fieldValues:                map[string]interface{}{},
+		statusMappings:             map[string]string{},
+		visibilityMappings:         map[string]string{},
+		originalFields:             map[string]string{},
+		userMappings:               map[string]string{},
+		users:                      newUserDirectory(),
+		labelRenames:               map[string]string{},
 		syncRoot:                   sync.Map{},
+		runID:                      newRunID(sourceProjectKey, targetProjectKey),
 	}
 
 	for _, option := range options {
 		option(m)
 	}
 
+	if m.ledger == nil {
+		return nil, errors.New("a ledger is required to know which issues were already migrated")
+	}
+
+	// Both clients share the transport, so the requests of every worker are paced together per host
+	if m.transport == nil {
+		m.transport = NewTransport(DefaultRequestsPerSecond, DefaultMaxRetries)
+	}
+
+	var err error
+	m.sourceClient, err = source.newClient(m.transport)
+	if err != nil {
+		return nil, err
+	}
+
+	m.targetClient, err = target.newClient(m.transport)
+	if err != nil {
+		return nil, err
+	}
+
 	return m, nil
 }
 
 func (s *migrator) Execute(jql string) (chan Result, error) {
 	results := make(chan Result)
 
-	currentUser, response, err := s.sourceClient.User.GetSelf()
+	sourceBoard, targetBoard, err := s.prepare()
 	if err != nil {
 		close(results)
 		return results, err
 	}
 
-	s.currentUser = currentUser
-
-	if err := checkProjectAccess(s.sourceClient, s.sourceProjectKey); err != nil {
-		close(results)
-		return results, fmt.Errorf("could not get source project: %w", err)
+	run := LedgerRun{
+		ID:               s.runID,
+		Kind:             RunMigrate,
+		JQL:              internal.SanitizeJQL(s.sourceProjectKey, jql),
+		Query:            internal.SanitizeJQL(s.sourceProjectKey, jql),
+		SourceProjectKey: s.sourceProjectKey,
+		TargetProjectKey: s.targetProjectKey,
+		StartedAt:        time.Now().UTC(),
 	}
 
-	if err := checkProjectAccess(s.targetClient, s.targetProjectKey); err != nil {
-		close(results)
-		return results, fmt.Errorf("could not get target project: %w", err)
+	if s.sync {
+		if lastRun, ok := s.ledger.LastSuccessfulRun(run.Query, s.sourceProjectKey, s.targetProjectKey); ok {
+			run.Since = &lastRun.StartedAt
+			run.JQL = internal.SanitizeJQLUpdatedSince(s.sourceProjectKey, jql, lastRun.StartedAt.In(s.getUserLocation()))
+			log.Printf("Syncing issues updated since run %s started on %s", lastRun.ID, lastRun.StartedAt)
+		} else {
+			log.Printf("No successful run of the query from %s to %s found, syncing every issue", s.sourceProjectKey, s.targetProjectKey)
+		}
 	}
 
-	if err := s.discoverFields(); err != nil {
-		close(results)
-		return results, err
-	}
+	jql = run.JQL
 
-	sourceBoard, err := getBoard(s.sourceClient, s.sourceProjectKey)
-	if err != nil {
-		close(results)
-		return results, fmt.Errorf("could not get source board: %w", err)
+	if s.resume {
+		if unfinishedRun, ok := s.ledger.LastUnfinishedRun(run.JQL, s.sourceProjectKey, s.targetProjectKey); ok {
+			run = unfinishedRun
+			s.runID = run.ID
+			log.Printf("Resuming run %s from issue #%d", run.ID, run.StartAt+1)
+		}
 	}
 
-	targetBoard, err := getBoard(s.targetClient, s.targetProjectKey)
-	if err != nil {
+	log.Printf("Run %s migrating %s to %s", s.runID, s.sourceProjectKey, s.targetProjectKey)
+
+	if err := s.ledger.StartRun(run); err != nil {
 		close(results)
-		return results, fmt.Errorf("could not get target board: %w", err)
+		return results, err
 	}
 
-	s.targetBoard = targetBoard
-
-	jql = internal.SanitizeJQL(s.sourceProjectKey, jql)
-
 	if err := s.migrateOpenSprints(sourceBoard.ID, targetBoard.ID); err != nil {
 		close(results)
 		return results, err
 	}
 
 	options := &jira.SearchOptions{
+		StartAt:    run.StartAt,
 		MaxResults: maxResultsPerSearch,
 		Fields:     []string{"key"}}
 
@@ -217,37 +411,73 @@ func (s *migrator) Execute(jql string) (chan Result, error) {
 		return results, err
 	}
 
-	if len(issues) == 0 {
+	if len(issues) == 0 && len(run.Failed) == 0 {
 		close(results)
-		return results, nil
+
+		// The run may have been interrupted while rewriting the references
+		if err := s.rewriteRunReferences(); err != nil {
+			log.Println(err)
+		}
+
+		return results, s.ledger.FinishRun(s.runID)
+	}
+
+	s.checkpoint = newCheckpoint(s.ledger, s.runID)
+
+	workerPoolSize := s.workerPoolSize
+	var controller *concurrencyController
+	if s.adaptiveWorkers {
+		workerPoolSize = s.maxWorkers
+		controller = newConcurrencyController(s.transport, s.minWorkers, s.maxWorkers)
+		go controller.run()
 	}
 
-	issueKeys := make(chan string, s.workerPoolSize)
+	pendingIssues := make(chan pendingIssue, workerPoolSize)
 	workers := &sync.WaitGroup{}
 
-	for i := 0; i < len(issues) && i < s.workerPoolSize; i++ {
+	for i := 0; i < len(issues)+len(run.Failed) && i < workerPoolSize; i++ {
 		workers.Add(1)
-		go s.worker(i, issueKeys, results, workers)
+		go s.worker(i, pendingIssues, results, workers, controller)
 	}
 
 	go func() {
+		defer close(results)
+
+		if controller != nil {
+			defer close(controller.stop)
+		}
+
+		// Issues that failed before the run was interrupted are behind the checkpoint
+		for _, sourceKey := range run.Failed {
+			pendingIssues <- pendingIssue{key: sourceKey}
+		}
+
 		for {
+			page := s.checkpoint.addPage(options.StartAt, len(issues))
 			for _, issue := range issues {
-				issueKeys <- issue.Key
+				pendingIssues <- pendingIssue{key: issue.Key, page: page}
 			}
 
 			if response.StartAt+response.MaxResults >= response.Total {
-				close(issueKeys)
+				close(pendingIssues)
 				workers.Wait()
-				close(results)
+
+				if err := s.rewriteRunReferences(); err != nil {
+					log.Println(err)
+				}
+
+				if err := s.ledger.FinishRun(s.runID); err != nil {
+					log.Println(err)
+				}
 				return
 			}
 
 			options.StartAt += response.MaxResults
 			issues, response, err = s.sourceClient.Issue.Search(jql, options)
 			if err != nil {
-				close(issueKeys)
-				close(results)
+				log.Printf("Could not search issues, the run can be resumed later: %s", parseResponseError("Search", response, err))
+				close(pendingIssues)
+				workers.Wait()
 				return
 			}
 		}
@@ -256,6 +486,75 @@ func (s *migrator) Execute(jql string) (chan Result, error) {
 	return results, nil
 }
 
+// prepare reads everything needed from both projects before issues can be migrated, without changing the target
+func (s *migrator) prepare() (sourceBoard *jira.Board, targetBoard *jira.Board, err error) {
+	if s.sourceInstance, err = getInstance(s.sourceClient); err != nil {
+		return nil, nil, errors.Wrap(err, "could not get source server info")
+	}
+
+	if s.targetInstance, err = getInstance(s.targetClient); err != nil {
+		return nil, nil, errors.Wrap(err, "could not get target server info")
+	}
+
+	log.Printf("Migrating from Jira %s to Jira %s", s.sourceInstance, s.targetInstance)
+
+	if s.adf && (!s.sourceInstance.isCloud() || !s.targetInstance.isCloud()) {
+		log.Println("Descriptions and comments are migrated as wiki markup, as only Jira Cloud supports documents")
+		s.adf = false
+	}
+
+	sourceUser, response, err := s.sourceClient.User.GetSelf()
+	if err != nil {
+		return nil, nil, parseResponseError("GetSelf", response, err)
+	}
+
+	s.sourceUser = sourceUser
+
+	currentUser, response, err := s.targetClient.User.GetSelf()
+	if err != nil {
+		return nil, nil, parseResponseError("GetSelf", response, err)
+	}
+
+	s.currentUser = currentUser
+
+	if err := checkProjectAccess(s.sourceClient, s.sourceProjectKey); err != nil {
+		return nil, nil, fmt.Errorf("could not get source project: %w", err)
+	}
+
+	if err := checkProjectAccess(s.targetClient, s.targetProjectKey); err != nil {
+		return nil, nil, fmt.Errorf("could not get target project: %w", err)
+	}
+
+	if err := s.discoverFields(); err != nil {
+		return nil, nil, err
+	}
+
+	s.checkOriginalFields()
+
+	sourceBoard, err = getBoard(s.sourceClient, s.sourceProjectKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get source board: %w", err)
+	}
+
+	targetBoard, err = getBoard(s.targetClient, s.targetProjectKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get target board: %w", err)
+	}
+
+	s.targetBoard = targetBoard
+
+	return sourceBoard, targetBoard, nil
+}
+
+// getUserLocation returns the time zone JQL dates are read in, which is the one of the source user
+func (s *migrator) getUserLocation() *time.Location {
+	if location, err := time.LoadLocation(s.sourceUser.TimeZone); err == nil && s.sourceUser.TimeZone != "" {
+		return location
+	}
+
+	return time.UTC
+}
+
 func getBoard(client *jira.Client, projectKey string) (*jira.Board, error) {
 	boards, response, err := client.Board.GetAllBoards(&jira.BoardListOptions{ProjectKeyOrID: projectKey})
 	if err != nil {
@@ -269,37 +568,37 @@ func getBoard(client *jira.Client, projectKey string) (*jira.Board, error) {
 	return &boards.Values[0], nil //TODO Support multiple board migration
 }
 
-func (s *migrator) worker(id int, issueKeys <-chan string, results chan<- Result, wg *sync.WaitGroup) {
-	for issueKey := range issueKeys {
-		for {
-			result := s.migrateIssue(issueKey)
-			if result.HasTooManyRequestsError() {
-				log.Println("Taking a break to respect the rate limit restrictions...")
-				time.Sleep(rateLimitRetryInterval)
-				continue
-			}
+// worker migrates the pending issues, waiting for its turn when the concurrency is adaptive
+func (s *migrator) worker(id int, pendingIssues <-chan pendingIssue, results chan<- Result, wg *sync.WaitGroup, controller *concurrencyController) {
+	for {
+		if controller != nil {
+			controller.limiter.acquire()
+		}
 
-			results <- result
+		pendingIssue, ok := <-pendingIssues
+		if !ok {
+			if controller != nil {
+				controller.limiter.release()
+			}
 			break
 		}
-	}
 
-	wg.Done()
-}
+		result := s.migrateIssue(pendingIssue.key)
 
-func parseResponseError(operation string, response *jira.Response, err error) error {
-	var out bytes.Buffer
-	if err == nil {
-		return nil
-	}
+		if controller != nil {
+			controller.limiter.release()
+			controller.done()
+		}
 
-	if response == nil {
-		return errors.New("invalid response")
+		failed := len(result.Errors) > 0 && !result.HasError(ErrAlreadyMigrated)
+		if err := s.checkpoint.done(pendingIssue.page, pendingIssue.key, failed); err != nil {
+			result.Errors = append(result.Errors, err)
+		}
+
+		results <- result
 	}
 
-	responseBody, _ := io.ReadAll(response.Body)
-	_ = json.Indent(&out, responseBody, "", "  ")
-	return errors.Errorf("%s: %s: %s", operation, err, out.String())
+	wg.Done()
 }
 
 func checkProjectAccess(client *jira.Client, projectKey string) error {
@@ -309,7 +608,7 @@ func checkProjectAccess(client *jira.Client, projectKey string) error {
 	}
 
 	if project == nil {
-		return fmt.Errorf("%s not found", projectKey)
+		return errors.Wrap(ErrNotFound, projectKey)
 	}
 
 	return nil
@@ -0,0 +1,258 @@
+package migration
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/natenho/go-jira"
+	"github.com/natenho/go-jira-migrate/internal"
+)
+
+// MirrorHealth is the state of a mirror, as reported by its health endpoint
+type MirrorHealth struct {
+	RunID string `json:"runId"`
+	// HighWaterMark is the update time up to which every source issue was mirrored
+	HighWaterMark time.Time  `json:"highWaterMark"`
+	StartedAt     time.Time  `json:"startedAt"`
+	LastPollAt    *time.Time `json:"lastPollAt,omitempty"`
+	LastSuccessAt *time.Time `json:"lastSuccessAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	Polls         int        `json:"polls"`
+	Issues        int        `json:"issues"`
+	Failures      int        `json:"failures"`
+	Stopped       bool       `json:"stopped"`
+}
+
+// IsHealthy tells whether the mirror is running and its last poll succeeded
+func (h MirrorHealth) IsHealthy() bool {
+	return !h.Stopped && h.LastError == ""
+}
+
+// Mirror polls the source project on an interval, migrating new issues and updating the ones already migrated
+type Mirror struct {
+	migrator      *migrator
+	jql           string
+	interval      time.Duration
+	sourceBoardID int
+	targetBoardID int
+
+	results  chan Result
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mutex  sync.Mutex
+	health MirrorHealth
+}
+
+// Mirror starts mirroring the issues selected by the query, from the high-water mark of the last mirror run
+func (s *migrator) Mirror(jql string, interval time.Duration) (*Mirror, error) {
+	sourceBoard, targetBoard, err := s.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	s.sync = true
+
+	query := internal.SanitizeJQL(s.sourceProjectKey, jql)
+
+	highWaterMark, ok := s.ledger.LastHighWaterMark(query, s.sourceProjectKey, s.targetProjectKey)
+	if !ok {
+		if lastRun, ok := s.ledger.LastSuccessfulRun(query, s.sourceProjectKey, s.targetProjectKey); ok {
+			highWaterMark = lastRun.StartedAt
+		}
+	}
+
+	run := LedgerRun{
+		ID:               s.runID,
+		Kind:             RunMirror,
+		JQL:              query,
+		Query:            query,
+		SourceProjectKey: s.sourceProjectKey,
+		TargetProjectKey: s.targetProjectKey,
+		StartedAt:        time.Now().UTC(),
+	}
+
+	if err := s.ledger.StartRun(run); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Run %s mirroring %s to %s every %s, from issues updated since %s", s.runID, s.sourceProjectKey, s.targetProjectKey, interval, highWaterMark)
+
+	m := &Mirror{
+		migrator:      s,
+		jql:           jql,
+		interval:      interval,
+		sourceBoardID: sourceBoard.ID,
+		targetBoardID: targetBoard.ID,
+		results:       make(chan Result),
+		stop:          make(chan struct{}),
+		health: MirrorHealth{
+			RunID:         s.runID,
+			HighWaterMark: highWaterMark,
+			StartedAt:     run.StartedAt,
+		},
+	}
+
+	go m.loop()
+
+	return m, nil
+}
+
+// Results returns the outcome of every issue mirrored, it is closed once the mirror stops
+func (m *Mirror) Results() <-chan Result {
+	return m.results
+}
+
+func (m *Mirror) Health() MirrorHealth {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.health
+}
+
+// Stop asks the mirror to stop after the current poll
+func (m *Mirror) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+func (m *Mirror) loop() {
+	defer close(m.results)
+
+	for {
+		m.poll()
+
+		select {
+		case <-m.stop:
+			if err := m.migrator.ledger.FinishRun(m.migrator.runID); err != nil {
+				log.Println(err)
+			}
+
+			m.mutex.Lock()
+			m.health.Stopped = true
+			m.mutex.Unlock()
+			return
+		case <-time.After(m.interval):
+		}
+	}
+}
+
+// poll mirrors the issues updated after the high-water mark, which only moves past issues mirrored without errors
+func (m *Mirror) poll() {
+	s := m.migrator
+	pollAt := time.Now().UTC()
+	highWaterMark := m.Health().HighWaterMark
+
+	issues, err := m.getUpdatedIssues(highWaterMark)
+	if err == nil {
+		err = s.migrateOpenSprints(m.sourceBoardID, m.targetBoardID)
+	}
+
+	if err != nil {
+		log.Printf("Could not poll %s: %s", s.sourceProjectKey, err)
+
+		m.mutex.Lock()
+		m.health.Polls++
+		m.health.LastPollAt = &pollAt
+		m.health.LastError = err.Error()
+		m.mutex.Unlock()
+		return
+	}
+
+	var failures int
+	var lastUpdated, firstFailedUpdated time.Time
+
+	mirroredIssues := make(chan jira.Issue)
+	mutex := &sync.Mutex{}
+	workers := &sync.WaitGroup{}
+
+	for i := 0; i < len(issues) && i < s.workerPoolSize; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+
+			for issue := range mirroredIssues {
+				result := s.migrateIssue(issue.Key)
+				updated := time.Time(issue.Fields.Updated)
+
+				if err := s.ledger.SetRunIssueFailed(s.runID, issue.Key, len(result.Errors) > 0); err != nil {
+					result.Errors = append(result.Errors, err)
+				}
+
+				mutex.Lock()
+				if len(result.Errors) > 0 {
+					failures++
+					if firstFailedUpdated.IsZero() || updated.Before(firstFailedUpdated) {
+						firstFailedUpdated = updated
+					}
+				} else if updated.After(lastUpdated) {
+					lastUpdated = updated
+				}
+				mutex.Unlock()
+
+				m.results <- result
+			}
+		}()
+	}
+
+	for _, issue := range issues {
+		mirroredIssues <- issue
+	}
+
+	close(mirroredIssues)
+	workers.Wait()
+
+	if err := s.rewriteRunReferences(); err != nil {
+		log.Println(err)
+	}
+
+	if !firstFailedUpdated.IsZero() && !lastUpdated.Before(firstFailedUpdated) {
+		// Issues updated at the same time as a failed one are mirrored again by the next poll
+		lastUpdated = firstFailedUpdated.Add(-time.Nanosecond)
+	}
+
+	if lastUpdated.After(highWaterMark) {
+		highWaterMark = lastUpdated
+		if err := s.ledger.SetRunHighWaterMark(s.runID, highWaterMark); err != nil {
+			log.Println(err)
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.health.Polls++
+	m.health.Issues += len(issues)
+	m.health.Failures += failures
+	m.health.LastPollAt = &pollAt
+	m.health.LastSuccessAt = &pollAt
+	m.health.LastError = ""
+	m.health.HighWaterMark = highWaterMark
+}
+
+// getUpdatedIssues searches the source issues updated after the high-water mark, or every issue when there is none
+func (m *Mirror) getUpdatedIssues(highWaterMark time.Time) ([]jira.Issue, error) {
+	s := m.migrator
+
+	jql := internal.SanitizeJQL(s.sourceProjectKey, m.jql)
+	if !highWaterMark.IsZero() {
+		jql = internal.SanitizeJQLUpdatedSince(s.sourceProjectKey, m.jql, highWaterMark.In(s.getUserLocation()))
+	}
+
+	options := &jira.SearchOptions{
+		MaxResults: maxResultsPerSearch,
+		Fields:     []string{"key", "updated"}}
+
+	var issues []jira.Issue
+	err := s.sourceClient.Issue.SearchPages(jql, options, func(issue jira.Issue) error {
+		// JQL dates have minute precision, so the issues mirrored by the last poll are found again
+		if issue.Fields != nil && time.Time(issue.Fields.Updated).After(highWaterMark) {
+			issues = append(issues, issue)
+		}
+		return nil
+	})
+
+	return issues, err
+}
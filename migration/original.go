@@ -0,0 +1,96 @@
+package migration
+
+import (
+	"log"
+	"time"
+
+	"github.com/natenho/go-jira"
+)
+
+const (
+	// OriginalCreated is the creation date of the source issue, as Jira sets the target one to the migration date
+	OriginalCreated = "created"
+	// OriginalUpdated is the last update date of the source issue
+	OriginalUpdated = "updated"
+	// OriginalResolved is the resolution date of the source issue
+	OriginalResolved = "resolved"
+	// OriginalReporter is the target user of the source reporter, set even when the reporter field cannot be
+	OriginalReporter = "reporter"
+)
+
+// jiraDateLayout is the format of the date picker fields
+const jiraDateLayout = "2006-01-02"
+
+// WithOriginalField fills a target field with an original value of the source issue, OriginalCreated,
+// OriginalUpdated or OriginalResolved into a date-time or date picker, and OriginalReporter into a user picker
+func WithOriginalField(value, targetFieldName string) Option {
+	return func(m *migrator) {
+		m.originalFields[value] = targetFieldName
+	}
+}
+
+// checkOriginalFields warns about the original fields missing from every target issue type
+func (s *migrator) checkOriginalFields() {
+	for value, targetFieldName := range s.originalFields {
+		found := false
+		for _, targetFields := range s.targetFieldPerIssueType {
+			for _, targetField := range targetFields {
+				found = found || targetField.Name == targetFieldName
+			}
+		}
+
+		if !found {
+			log.Printf("Original %s field %q not found on the create screens of %s, it will not be filled", value, targetFieldName, s.targetProjectKey)
+		}
+	}
+}
+
+// setOriginalFields fills the original fields available on the create screen of the target issue type
+func (s *migrator) setOriginalFields(sourceIssue, targetIssue *jira.Issue) {
+	for _, targetField := range s.targetFieldPerIssueType[targetIssue.Fields.Type.Name] {
+		for value, targetFieldName := range s.originalFields {
+			if targetField.Name != targetFieldName {
+				continue
+			}
+
+			if fieldValue := s.getOriginalValue(sourceIssue, value, targetField); fieldValue != nil {
+				targetIssue.Fields.Unknowns[targetField.Key] = fieldValue
+			}
+		}
+	}
+}
+
+// getOriginalValue returns the value of the target field, or nil when the source issue has none
+func (s *migrator) getOriginalValue(sourceIssue *jira.Issue, value string, targetField jira.Field) interface{} {
+	var date time.Time
+
+	switch value {
+	case OriginalCreated:
+		date = time.Time(sourceIssue.Fields.Created)
+	case OriginalUpdated:
+		date = time.Time(sourceIssue.Fields.Updated)
+	case OriginalResolved:
+		date = time.Time(sourceIssue.Fields.Resolutiondate)
+	case OriginalReporter:
+		if sourceIssue.Fields.Reporter == nil {
+			return nil
+		}
+
+		if targetUser := s.mapUser(sourceIssue.Fields.Reporter); targetUser != nil {
+			return targetUser
+		}
+		return nil
+	default:
+		return nil
+	}
+
+	if date.IsZero() {
+		return nil
+	}
+
+	if targetField.Schema.Type == "date" {
+		return date.Format(jiraDateLayout)
+	}
+
+	return date.Format(jiraTimeLayout)
+}
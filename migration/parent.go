@@ -1,13 +1,25 @@
 package migration
 
-import "github.com/natenho/go-jira"
+import (
+	"github.com/natenho/go-jira"
+	"github.com/natenho/go-jira-migrate/internal"
+)
+
+// Issues of Jira Server are children of epics by this field, as the parent field is only used by sub-tasks
+const epicLinkField = "Epic Link"
 
 func (s *migrator) migrateParent(sourceIssue *jira.Issue, targetIssue *jira.Issue) error {
-	if sourceIssue.Fields.Parent == nil {
+	sourceParentKey := s.getSourceParentKey(sourceIssue)
+	if sourceParentKey == "" {
+		return nil
+	}
+
+	if targetKey, ok := s.ledger.TargetKey(sourceParentKey); ok {
+		s.setTargetParent(sourceIssue, targetIssue, targetKey)
 		return nil
 	}
 
-	parentIssue, response, err := s.sourceClient.Issue.Get(sourceIssue.Fields.Parent.ID, nil)
+	parentIssue, response, err := s.sourceClient.Issue.Get(sourceParentKey, nil)
 	if err != nil {
 		return err
 	}
@@ -22,7 +34,51 @@ func (s *migrator) migrateParent(sourceIssue *jira.Issue, targetIssue *jira.Issu
 		return parentIssueMigrateResult.Errors[0]
 	}
 
-	targetIssue.Fields.Parent = &jira.Parent{Key: parentIssueMigrateResult.TargetKey}
+	s.setTargetParent(sourceIssue, targetIssue, parentIssueMigrateResult.TargetKey)
 
 	return nil
 }
+
+// getSourceParentKey returns the key of the parent of a source issue, which is its epic on Jira Server
+func (s *migrator) getSourceParentKey(sourceIssue *jira.Issue) string {
+	if sourceIssue.Fields.Parent != nil {
+		return sourceIssue.Fields.Parent.Key
+	}
+
+	if s.sourceInstance.isCloud() {
+		return ""
+	}
+
+	epicKey, _ := s.getCustomFieldValue(sourceIssue, epicLinkField).(string)
+	return epicKey
+}
+
+// setTargetParent sets the parent of a target issue, or its epic on Jira Server
+func (s *migrator) setTargetParent(sourceIssue *jira.Issue, targetIssue *jira.Issue, parentKey string) {
+	if s.targetInstance.isCloud() || sourceIssue.Fields.Type.Subtask {
+		targetIssue.Fields.Parent = &jira.Parent{Key: parentKey}
+		return
+	}
+
+	epicLink, ok := internal.SliceFind(s.targetFieldPerIssueType[targetIssue.Fields.Type.Name], func(field jira.Field) bool {
+		return field.Name == epicLinkField
+	})
+
+	if ok {
+		targetIssue.Fields.Unknowns[epicLink.Key] = parentKey
+	}
+}
+
+// getTargetParentKey returns the key of the parent of a target issue, which is its epic on Jira Server
+func (s *migrator) getTargetParentKey(targetIssue *jira.Issue) string {
+	if targetIssue.Fields.Parent != nil {
+		return targetIssue.Fields.Parent.Key
+	}
+
+	if s.targetInstance.isCloud() {
+		return ""
+	}
+
+	epicKey, _ := getFieldValueByName(s.targetFieldPerIssueType, targetIssue, epicLinkField).(string)
+	return epicKey
+}
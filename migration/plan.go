@@ -0,0 +1,365 @@
+package migration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/natenho/go-jira"
+	"github.com/natenho/go-jira-migrate/internal"
+)
+
+// Plan describes what a migration would create on the target, without creating anything
+type Plan struct {
+	SourceProjectKey string              `json:"sourceProjectKey"`
+	TargetProjectKey string              `json:"targetProjectKey"`
+	JQL              string              `json:"jql"`
+	CustomFields     map[string][]string `json:"customFields"`
+	SprintsToCreate  []string            `json:"sprintsToCreate"`
+	Issues           []PlannedIssue      `json:"issues"`
+}
+
+// PlannedIssue describes how a single source issue would be migrated
+type PlannedIssue struct {
+	SourceKey string `json:"sourceKey"`
+	Summary   string `json:"summary"`
+	IssueType string `json:"issueType"`
+	// TargetKey is set when the issue was already migrated and would be skipped
+	TargetKey string `json:"targetKey,omitempty"`
+	// PulledInBy is set when the issue is not selected by the query, but is the parent or a link of a planned issue
+	PulledInBy       string   `json:"pulledInBy,omitempty"`
+	Fields           []string `json:"fields,omitempty"`
+	Parent           string   `json:"parent,omitempty"`
+	LinkedIssues     []string `json:"linkedIssues,omitempty"`
+	AssigneeFallback string   `json:"assigneeFallback,omitempty"`
+	ReporterFallback string   `json:"reporterFallback,omitempty"`
+	Errors           []string `json:"errors,omitempty"`
+}
+
+func (p Plan) String() string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "Migration plan from %s to %s\n", p.SourceProjectKey, p.TargetProjectKey)
+	fmt.Fprintf(&builder, "Query: %s\n", p.JQL)
+
+	fmt.Fprintf(&builder, "\nCustom fields (%d):\n", len(p.CustomFields))
+	for _, sourceField := range sortedKeys(p.CustomFields) {
+		fmt.Fprintf(&builder, "  %s -> %s\n", sourceField, strings.Join(p.CustomFields[sourceField], ", "))
+	}
+
+	fmt.Fprintf(&builder, "\nSprints to create (%d):\n", len(p.SprintsToCreate))
+	for _, sprint := range p.SprintsToCreate {
+		fmt.Fprintf(&builder, "  %s\n", sprint)
+	}
+
+	var toCreate, skipped, pulledIn, fallbacks int
+	for _, issue := range p.Issues {
+		switch {
+		case issue.TargetKey != "":
+			skipped++
+		case issue.PulledInBy != "":
+			pulledIn++
+			toCreate++
+		default:
+			toCreate++
+		}
+
+		if issue.AssigneeFallback != "" || issue.ReporterFallback != "" {
+			fallbacks++
+		}
+	}
+
+	fmt.Fprintf(&builder, "\nIssues (%d to create, %d pulled in by parents or links, %d already migrated, %d with user fallbacks):\n",
+		toCreate, pulledIn, skipped, fallbacks)
+
+	for _, issue := range p.Issues {
+		fmt.Fprintf(&builder, "  %s [%s] %s\n", issue.SourceKey, issue.IssueType, issue.Summary)
+
+		if issue.TargetKey != "" {
+			fmt.Fprintf(&builder, "    already migrated to %s\n", issue.TargetKey)
+			continue
+		}
+
+		if issue.PulledInBy != "" {
+			fmt.Fprintf(&builder, "    pulled in by %s\n", issue.PulledInBy)
+		}
+
+		if len(issue.Fields) > 0 {
+			fmt.Fprintf(&builder, "    fields: %s\n", strings.Join(issue.Fields, ", "))
+		}
+
+		if issue.Parent != "" {
+			fmt.Fprintf(&builder, "    parent: %s\n", issue.Parent)
+		}
+
+		if len(issue.LinkedIssues) > 0 {
+			fmt.Fprintf(&builder, "    links: %s\n", strings.Join(issue.LinkedIssues, ", "))
+		}
+
+		if issue.AssigneeFallback != "" {
+			fmt.Fprintf(&builder, "    assignee %s cannot be set, falls back to the migration user\n", issue.AssigneeFallback)
+		}
+
+		if issue.ReporterFallback != "" {
+			fmt.Fprintf(&builder, "    reporter %s cannot be set, falls back to the migration user\n", issue.ReporterFallback)
+		}
+
+		for _, err := range issue.Errors {
+			fmt.Fprintf(&builder, "    error: %s\n", err)
+		}
+	}
+
+	return builder.String()
+}
+
+// Plan runs every read of a migration and reports what would be created, without writing to the target
+func (s *migrator) Plan(jql string) (*Plan, error) {
+	sourceBoard, targetBoard, err := s.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	jql = internal.SanitizeJQL(s.sourceProjectKey, jql)
+
+	plan := &Plan{
+		SourceProjectKey: s.sourceProjectKey,
+		TargetProjectKey: s.targetProjectKey,
+		JQL:              jql,
+		CustomFields:     map[string][]string{},
+	}
+
+	if err := s.planCustomFields(plan); err != nil {
+		return nil, err
+	}
+
+	if s.importSprints {
+		missingSprints, err := s.mapOpenSprints(sourceBoard.ID, targetBoard.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sprint := range missingSprints {
+			plan.SprintsToCreate = append(plan.SprintsToCreate, sprint.Name)
+		}
+	}
+
+	var selectedKeys []string
+	options := &jira.SearchOptions{
+		MaxResults: maxResultsPerSearch,
+		Fields:     []string{"key"}}
+
+	err = s.sourceClient.Issue.SearchPages(jql, options, func(issue jira.Issue) error {
+		selectedKeys = append(selectedKeys, issue.Key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	planned := map[string]bool{}
+	pending := map[string]string{}
+	for _, key := range selectedKeys {
+		pending[key] = ""
+	}
+
+	for len(pending) > 0 {
+		issues := s.planIssues(pending)
+		pending = map[string]string{}
+
+		for _, issue := range issues {
+			planned[issue.SourceKey] = true
+		}
+
+		for _, issue := range issues {
+			plan.Issues = append(plan.Issues, issue)
+
+			if issue.TargetKey != "" {
+				continue
+			}
+
+			for _, relatedKey := range append([]string{issue.Parent}, issue.LinkedIssues...) {
+				if relatedKey == "" || planned[relatedKey] {
+					continue
+				}
+
+				if _, ok := s.ledger.TargetKey(relatedKey); ok {
+					continue
+				}
+
+				if _, ok := pending[relatedKey]; !ok {
+					pending[relatedKey] = issue.SourceKey
+				}
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+func (s *migrator) planCustomFields(plan *Plan) error {
+	sourceFields, response, err := s.sourceClient.Field.GetList()
+	if err != nil {
+		return parseResponseError("GetList", response, err)
+	}
+
+	sourceFieldNames := map[string]string{}
+	for _, sourceField := range sourceFields {
+		sourceFieldNames[sourceField.Key] = sourceField.Name
+	}
+
+	for sourceFieldKey, targetFields := range s.sourceTargetCustomFieldMap {
+		sourceFieldName := fmt.Sprintf("%s (%s)", sourceFieldNames[sourceFieldKey], sourceFieldKey)
+		for _, targetField := range targetFields {
+			plan.CustomFields[sourceFieldName] = append(plan.CustomFields[sourceFieldName], fmt.Sprintf("%s (%s)", targetField.Name, targetField.Key))
+		}
+	}
+
+	return nil
+}
+
+// planIssues plans the given issues in parallel, keyed by the issue that pulled them in
+func (s *migrator) planIssues(issueKeys map[string]string) []PlannedIssue {
+	keys := sortedKeys(issueKeys)
+	issues := make([]PlannedIssue, len(keys))
+
+	indexes := make(chan int)
+	workers := &sync.WaitGroup{}
+
+	for i := 0; i < len(keys) && i < s.workerPoolSize; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for index := range indexes {
+				issues[index] = s.planIssue(keys[index])
+				issues[index].PulledInBy = issueKeys[keys[index]]
+			}
+		}()
+	}
+
+	for index := range keys {
+		indexes <- index
+	}
+
+	close(indexes)
+	workers.Wait()
+
+	return issues
+}
+
+func (s *migrator) planIssue(issueKey string) PlannedIssue {
+	planned := PlannedIssue{SourceKey: issueKey}
+
+	sourceIssue, err := s.getSourceIssueByKey(issueKey)
+	if err != nil {
+		planned.Errors = append(planned.Errors, err.Error())
+		return planned
+	}
+
+	planned.Summary = sourceIssue.Fields.Summary
+	planned.IssueType = sourceIssue.Fields.Type.Name
+
+	if targetKey, ok := s.ledger.TargetKey(sourceIssue.Key); ok {
+		planned.TargetKey = targetKey
+		return planned
+	}
+
+	if sourceIssue.Fields.Project.Key != s.sourceProjectKey {
+		planned.Errors = append(planned.Errors, fmt.Sprintf("issue %s does not belong to %s", sourceIssue.Key, s.sourceProjectKey))
+		return planned
+	}
+
+	if _, ok := s.targetFieldPerIssueType[planned.IssueType]; !ok {
+		planned.Errors = append(planned.Errors, fmt.Sprintf("issue type %s not found in %s", planned.IssueType, s.targetProjectKey))
+	}
+
+	targetIssue, err := s.buildTargetIssue(sourceIssue)
+	if err != nil {
+		planned.Errors = append(planned.Errors, err.Error())
+		return planned
+	}
+
+	planned.Fields = s.getPlannedFieldNames(targetIssue)
+
+	if sourceIssue.Fields.Assignee != nil && (targetIssue.Fields.Assignee == nil || targetIssue.Fields.Assignee == s.currentUser) {
+		planned.AssigneeFallback = sourceIssue.Fields.Assignee.DisplayName
+	}
+
+	if sourceIssue.Fields.Reporter != nil && targetIssue.Fields.Reporter == nil {
+		planned.ReporterFallback = sourceIssue.Fields.Reporter.DisplayName
+	}
+
+	if sourceParentKey := s.getSourceParentKey(sourceIssue); sourceParentKey != "" {
+		if parentIssue, err := s.getSourceIssueByKey(sourceParentKey); err != nil {
+			planned.Errors = append(planned.Errors, err.Error())
+		} else if parentIssue.Fields.Project.Key == s.sourceProjectKey {
+			planned.Parent = parentIssue.Key
+		}
+	}
+
+	for _, link := range sourceIssue.Fields.IssueLinks {
+		for _, linkedIssue := range []*jira.Issue{link.InwardIssue, link.OutwardIssue} {
+			if linkedIssue == nil {
+				continue
+			}
+
+			if _, ok := s.ledger.TargetKey(linkedIssue.Key); ok {
+				continue
+			}
+
+			sourceLinkedIssue, err := s.getSourceIssueByKey(linkedIssue.Key)
+			if err != nil {
+				planned.Errors = append(planned.Errors, err.Error())
+				continue
+			}
+
+			if s.canMigrateLinkedIssue(sourceLinkedIssue) {
+				planned.LinkedIssues = append(planned.LinkedIssues, sourceLinkedIssue.Key)
+			}
+		}
+	}
+
+	return planned
+}
+
+func (s *migrator) getPlannedFieldNames(targetIssue *jira.Issue) []string {
+	fieldNames := []string{"summary", "description", "labels"}
+
+	if targetIssue.Fields.Priority != nil {
+		fieldNames = append(fieldNames, "priority")
+	}
+
+	if targetIssue.Fields.Assignee != nil {
+		fieldNames = append(fieldNames, "assignee")
+	}
+
+	if targetIssue.Fields.Reporter != nil {
+		fieldNames = append(fieldNames, "reporter")
+	}
+
+	for fieldKey := range targetIssue.Fields.Unknowns {
+		fieldName := fieldKey
+		if field, ok := internal.SliceFind(s.targetFieldPerIssueType[targetIssue.Fields.Type.Name], func(field jira.Field) bool {
+			return field.Key == fieldKey
+		}); ok && field.Name != "" {
+			fieldName = field.Name
+		}
+
+		fieldNames = append(fieldNames, fieldName)
+	}
+
+	sort.Strings(fieldNames[3:])
+
+	return fieldNames
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
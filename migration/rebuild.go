@@ -0,0 +1,243 @@
+package migration
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/natenho/go-jira"
+	"github.com/pkg/errors"
+)
+
+const rebuildRunID = "rebuild"
+
+// RebuildLedger scans the target project for issues linked to their original issue and records them in the ledger,
+// with the comments, worklogs, attachments and links already found on them, so they are not migrated again
+func (s *migrator) RebuildLedger() (int, error) {
+	var recoveredKeys []string
+
+	err := s.findMigratedIssues(func(sourceKey string, targetIssue jira.Issue) error {
+		if targetKey, ok := s.ledger.TargetKey(sourceKey); ok && targetKey != targetIssue.Key {
+			log.Printf("%s is recorded as migrated to %s, ignoring %s", sourceKey, targetKey, targetIssue.Key)
+			return nil
+		}
+
+		if err := s.ledger.SetTarget(sourceKey, targetIssue.Key, rebuildRunID); err != nil {
+			return err
+		}
+
+		for _, step := range []string{StepCreate, StepRemoteLink} {
+			if err := s.ledger.SetStep(sourceKey, step, nil); err != nil {
+				return err
+			}
+		}
+
+		recoveredKeys = append(recoveredKeys, sourceKey)
+		return nil
+	})
+	if err != nil {
+		return len(recoveredKeys), err
+	}
+
+	// The items are recovered once every issue is, as a link needs the target issues of both sides
+	for _, sourceKey := range recoveredKeys {
+		if err := s.rebuildItems(sourceKey); err != nil {
+			return len(recoveredKeys), errors.Wrapf(err, "could not recover the items of %s", sourceKey)
+		}
+	}
+
+	return len(recoveredKeys), nil
+}
+
+// rebuildItems records the source items of the issue found on its target issue
+func (s *migrator) rebuildItems(sourceKey string) error {
+	targetKey, _ := s.ledger.TargetKey(sourceKey)
+
+	sourceIssue, err := s.getSourceIssueByKey(sourceKey)
+	if err != nil {
+		return err
+	}
+
+	targetIssue, err := s.getTargetIssueByKey(targetKey)
+	if err != nil {
+		return err
+	}
+
+	for _, rebuild := range []func(sourceIssue, targetIssue *jira.Issue) error{
+		s.rebuildComments,
+		s.rebuildWorklogs,
+		s.rebuildAttachments,
+		s.rebuildLinks,
+	} {
+		if err := rebuild(sourceIssue, targetIssue); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebuildComments pairs the source comments with the target comments whose header has their date, and finds the
+// history comment
+func (s *migrator) rebuildComments(sourceIssue, targetIssue *jira.Issue) error {
+	sourceComments, err := getComments(s.sourceClient, sourceIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	targetComments, err := getComments(s.targetClient, targetIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	paired := map[string]bool{}
+	for _, sourceComment := range sourceComments {
+		for _, targetComment := range targetComments {
+			if paired[targetComment.ID] || !strings.Contains(targetComment.Body, "On "+sourceComment.Created+" ") {
+				continue
+			}
+
+			paired[targetComment.ID] = true
+			if err := s.ledger.SetItem(sourceIssue.Key, StepComments, sourceComment.ID, targetComment.ID); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	for _, targetComment := range targetComments {
+		if !paired[targetComment.ID] && strings.Contains(targetComment.Body, "Change history of the original issue "+sourceIssue.Key) {
+			return s.ledger.SetItem(sourceIssue.Key, StepHistory, HistoryComment, targetComment.ID)
+		}
+	}
+
+	return nil
+}
+
+// rebuildWorklogs pairs the source worklogs with the target worklogs started at the same time for the same time spent
+func (s *migrator) rebuildWorklogs(sourceIssue, targetIssue *jira.Issue) error {
+	sourceWorklogs, err := getWorklogs(s.sourceClient, sourceIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	targetWorklogs, err := getWorklogs(s.targetClient, targetIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	paired := map[string]bool{}
+	for _, sourceWorklog := range sourceWorklogs {
+		sourceStarted, _ := time.Parse(jiraTimeLayout, sourceWorklog.Started)
+
+		for _, targetWorklog := range targetWorklogs {
+			targetStarted, _ := time.Parse(jiraTimeLayout, targetWorklog.Started)
+			if paired[targetWorklog.ID] || !targetStarted.Equal(sourceStarted) || targetWorklog.TimeSpentSeconds != sourceWorklog.TimeSpentSeconds {
+				continue
+			}
+
+			paired[targetWorklog.ID] = true
+			if err := s.ledger.SetItem(sourceIssue.Key, StepWorklogs, sourceWorklog.ID, targetWorklog.ID); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+// rebuildAttachments pairs the source attachments with the target attachments of the same name and size, and finds
+// the history attachments
+func (s *migrator) rebuildAttachments(sourceIssue, targetIssue *jira.Issue) error {
+	paired := map[string]bool{}
+	for _, sourceAttachment := range sourceIssue.Fields.Attachments {
+		if sourceAttachment == nil {
+			continue
+		}
+
+		for _, targetAttachment := range targetIssue.Fields.Attachments {
+			if targetAttachment == nil || paired[targetAttachment.ID] || targetAttachment.Filename != sourceAttachment.Filename || targetAttachment.Size != sourceAttachment.Size {
+				continue
+			}
+
+			paired[targetAttachment.ID] = true
+			if err := s.ledger.SetItem(sourceIssue.Key, StepAttachments, sourceAttachment.ID, attachmentItem(targetAttachment)); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	for _, format := range []string{HistoryJSON, HistoryCSV} {
+		for _, targetAttachment := range targetIssue.Fields.Attachments {
+			if targetAttachment != nil && !paired[targetAttachment.ID] && targetAttachment.Filename == fmt.Sprintf("%s-history.%s", sourceIssue.Key, format) {
+				if err := s.ledger.SetItem(sourceIssue.Key, StepHistory, format, targetAttachment.ID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// rebuildLinks records the source links found between the target issues of both sides
+func (s *migrator) rebuildLinks(sourceIssue, targetIssue *jira.Issue) error {
+	for _, sourceLink := range sourceIssue.Fields.IssueLinks {
+		linkedIssue := sourceLink.OutwardIssue
+		if linkedIssue == nil {
+			linkedIssue = sourceLink.InwardIssue
+		}
+
+		if linkedIssue == nil {
+			continue
+		}
+
+		targetLinkedKey, ok := s.ledger.TargetKey(linkedIssue.Key)
+		if !ok {
+			continue
+		}
+
+		for _, targetLink := range targetIssue.Fields.IssueLinks {
+			if targetLink.Type.Name != sourceLink.Type.Name || !isLinkedTo(targetLink, targetLinkedKey) {
+				continue
+			}
+
+			if err := s.ledger.SetItem(sourceIssue.Key, StepLinks, sourceLink.ID, ""); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+// isLinkedTo tells whether the other side of a link, as listed on an issue, is the given issue
+func isLinkedTo(link *jira.IssueLink, issueKey string) bool {
+	return (link.OutwardIssue != nil && link.OutwardIssue.Key == issueKey) || (link.InwardIssue != nil && link.InwardIssue.Key == issueKey)
+}
+
+// findMigratedIssues scans the target project for issues linked to their original issue by linkToOriginalIssue
+func (s *migrator) findMigratedIssues(f func(sourceKey string, targetIssue jira.Issue) error) error {
+	jql := fmt.Sprintf("project = %s ORDER BY key ASC", s.targetProjectKey)
+	options := &jira.SearchOptions{
+		MaxResults: maxResultsPerSearch,
+		Fields:     []string{"key"}}
+
+	return s.targetClient.Issue.SearchPages(jql, options, func(targetIssue jira.Issue) error {
+		remoteLinks, response, err := s.targetClient.Issue.GetRemoteLinks(targetIssue.Key)
+		if err != nil {
+			return parseResponseError("GetRemoteLinks", response, err)
+		}
+
+		sourceKey, ok := s.getOriginalIssueKey(*remoteLinks)
+		if !ok {
+			return nil
+		}
+
+		return f(sourceKey, targetIssue)
+	})
+}
@@ -0,0 +1,152 @@
+package migration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeJira answers the requests of a migrator with canned responses by method and path, recording every request
+type fakeJira struct {
+	server    *httptest.Server
+	responses map[string]string
+
+	mutex    sync.Mutex
+	requests []string
+}
+
+func newFakeJira(t *testing.T, responses map[string]string) *fakeJira {
+	f := &fakeJira{responses: responses}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := r.Method + " " + r.URL.Path
+
+		f.mutex.Lock()
+		f.requests = append(f.requests, request)
+		f.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if response, ok := f.responses[request]; ok {
+			w.Write([]byte(response))
+			return
+		}
+
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errorMessages":["not found"]}`))
+	}))
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+// requestsWithMethod returns the requests made with the method, such as POST
+func (f *fakeJira) requestsWithMethod(method string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var requests []string
+	for _, request := range f.requests {
+		if strings.HasPrefix(request, method+" ") {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests
+}
+
+func newTestMigrator(t *testing.T, source, target *fakeJira, options ...Option) *migrator {
+	ledger, err := OpenLedger("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options = append([]Option{WithLedger(ledger), WithTransport(NewTransport(0, 0))}, options...)
+
+	m, err := NewMigrator(
+		Connection{URL: source.server.URL, User: "user", Token: "token"},
+		Connection{URL: target.server.URL, User: "user", Token: "token"},
+		"SRC", "TGT", options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := m.(*migrator)
+	s.sourceInstance = &instance{DeploymentType: deploymentServer}
+	s.targetInstance = &instance{DeploymentType: deploymentServer}
+
+	return s
+}
+
+func TestSyncAfterRebuildLedger(t *testing.T) {
+	const (
+		status       = `"status": {"name": "Done", "statusCategory": {"key": "done", "name": "Done"}}`
+		noComments   = `{"comments": [], "total": 0}`
+		noWorklogs   = `{"worklogs": [], "total": 0}`
+		editMeta     = `{"fields": {"summary": {}, "labels": {}, "description": {}}}`
+		commentDate  = "2020-01-02T03:04:05.000+0000"
+		worklogStart = "2020-01-02T03:04:05.000+0000"
+	)
+
+	source := newFakeJira(t, map[string]string{
+		"GET /rest/api/2/issue/SRC-1": `{"id": "1001", "key": "SRC-1", "fields": {
+			"summary": "First", "issuetype": {"name": "Task"}, "project": {"key": "SRC"}, ` + status + `,
+			"attachment": [{"id": "100", "filename": "a.txt", "size": 3}],
+			"issuelinks": [{"id": "10", "type": {"name": "Blocks"}, "outwardIssue": {"key": "SRC-2"}}]}}`,
+		"GET /rest/api/2/issue/SRC-1/comment": `{"comments": [{"id": "1", "body": "hello", "created": "` + commentDate + `"}], "total": 1}`,
+		"GET /rest/api/2/issue/SRC-1/worklog": `{"worklogs": [{"id": "5", "started": "` + worklogStart + `", "timeSpentSeconds": 60}], "total": 1}`,
+		"GET /rest/api/2/issue/SRC-2": `{"id": "1002", "key": "SRC-2", "fields": {
+			"summary": "Second", "issuetype": {"name": "Task"}, "project": {"key": "SRC"}, ` + status + `,
+			"issuelinks": [{"id": "10", "type": {"name": "Blocks"}, "inwardIssue": {"key": "SRC-1"}}]}}`,
+		"GET /rest/api/2/issue/SRC-2/comment": noComments,
+		"GET /rest/api/2/issue/SRC-2/worklog": noWorklogs,
+	})
+
+	target := newFakeJira(t, nil)
+	target.responses = map[string]string{
+		"GET /rest/api/2/search":                 `{"startAt": 0, "maxResults": 100, "total": 2, "issues": [{"key": "TGT-1"}, {"key": "TGT-2"}]}`,
+		"GET /rest/api/2/issue/TGT-1/remotelink": `[{"id": 1, "object": {"url": "` + source.server.URL + `/browse/SRC-1", "title": "Original Issue - ` + source.server.URL + `/browse/SRC-1"}}]`,
+		"GET /rest/api/2/issue/TGT-2/remotelink": `[{"id": 2, "object": {"url": "` + source.server.URL + `/browse/SRC-2", "title": "Original Issue - ` + source.server.URL + `/browse/SRC-2"}}]`,
+		"GET /rest/api/2/issue/TGT-1": `{"id": "2001", "key": "TGT-1", "fields": {
+			"summary": "First", "issuetype": {"name": "Task"}, "project": {"key": "TGT"}, ` + status + `,
+			"attachment": [{"id": "200", "filename": "a.txt", "size": 3}],
+			"issuelinks": [{"id": "20", "type": {"name": "Blocks"}, "outwardIssue": {"key": "TGT-2"}}]}}`,
+		"GET /rest/api/2/issue/TGT-1/comment": `{"comments": [{"id": "2", "body": "_On ` + commentDate + ` Anonymous wrote:_\n\nhello", "created": "2024-05-06T07:08:09.000+0000"}], "total": 1}`,
+		"GET /rest/api/2/issue/TGT-1/worklog": `{"worklogs": [{"id": "6", "started": "2020-01-02T04:04:05.000+0100", "timeSpentSeconds": 60}], "total": 1}`,
+		"GET /rest/api/2/issue/TGT-2": `{"id": "2002", "key": "TGT-2", "fields": {
+			"summary": "Second", "issuetype": {"name": "Task"}, "project": {"key": "TGT"}, ` + status + `,
+			"issuelinks": [{"id": "20", "type": {"name": "Blocks"}, "inwardIssue": {"key": "TGT-1"}}]}}`,
+		"GET /rest/api/2/issue/TGT-2/comment":  noComments,
+		"GET /rest/api/2/issue/TGT-1/editmeta": editMeta,
+		"GET /rest/api/2/issue/TGT-2/editmeta": editMeta,
+		"GET /rest/api/2/issue/TGT-2/worklog":  noWorklogs,
+	}
+
+	s := newTestMigrator(t, source, target, WithSync(true))
+
+	recovered, err := s.RebuildLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recovered != 2 {
+		t.Fatalf("got %d issues recovered, want 2", recovered)
+	}
+
+	for _, sourceKey := range []string{"SRC-1", "SRC-2"} {
+		result := s.migrateIssue(sourceKey)
+		if len(result.Errors) > 0 {
+			t.Errorf("%s: %v", sourceKey, result.Errors)
+		}
+	}
+
+	if posts := append(source.requestsWithMethod(http.MethodPost), target.requestsWithMethod(http.MethodPost)...); len(posts) > 0 {
+		t.Errorf("got %v after rebuilding the ledger, want no POST", posts)
+	}
+}
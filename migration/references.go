@@ -0,0 +1,315 @@
+package migration
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/natenho/go-jira"
+	"github.com/pkg/errors"
+)
+
+// jiraTimeLayout is the format of the dates in Jira responses
+const jiraTimeLayout = "2006-01-02T15:04:05.000-0700"
+
+// ReferenceRewrite is a reference to a source issue replaced in the text of a target issue
+type ReferenceRewrite struct {
+	RunID     string    `json:"runId"`
+	Time      time.Time `json:"time"`
+	TargetKey string    `json:"targetKey"`
+	SourceKey string    `json:"sourceKey"`
+	// Field is summary, description or comment
+	Field     string `json:"field"`
+	CommentID string `json:"commentId,omitempty"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// WithReferenceRewriting replaces the keys and links of migrated source issues found in the text of the issues
+// migrated by a run, once every issue is created, recording every rewrite in the audit file
+func WithReferenceRewriting(auditPath string) Option {
+	return func(m *migrator) {
+		m.rewriteReferences = true
+		m.referenceAuditPath = auditPath
+	}
+}
+
+// referencesIssueItem is the item of StepReferences for the summary and description of the issue, the other items
+// being its target comments
+const referencesIssueItem = "issue"
+
+// issueReferencePattern matches issue keys, optionally in a link to the source instance
+func (s *migrator) issueReferencePattern() *regexp.Regexp {
+	s.referencePatternOnce.Do(func() {
+		s.referencePattern = regexp.MustCompile(`(` + regexp.QuoteMeta(browseURL(s.sourceClient)) + `)?\b([A-Z][A-Z0-9_]+-[0-9]+)\b`)
+	})
+
+	return s.referencePattern
+}
+
+func browseURL(client *jira.Client) string {
+	return siteURL(client) + "/browse/"
+}
+
+// siteURL returns the base URL of a client without the trailing slash
+func siteURL(client *jira.Client) string {
+	baseURL := client.GetBaseURL()
+	return strings.TrimSuffix(baseURL.String(), "/")
+}
+
+// rewriteIssueKeys replaces the keys and links of the migrated source issues by the target ones. References to the
+// issue itself are kept, as they are the link to the original issue added by the migration.
+func (s *migrator) rewriteIssueKeys(text, sourceKey string) (string, []ReferenceRewrite) {
+	var rewrites []ReferenceRewrite
+
+	pattern := s.issueReferencePattern()
+	rewrittenText := pattern.ReplaceAllStringFunc(text, func(reference string) string {
+		match := pattern.FindStringSubmatch(reference)
+		referencedKey := match[2]
+
+		if referencedKey == sourceKey {
+			return reference
+		}
+
+		targetKey, ok := s.ledger.TargetKey(referencedKey)
+		if !ok {
+			return reference
+		}
+
+		rewritten := targetKey
+		if match[1] != "" {
+			rewritten = browseURL(s.targetClient) + targetKey
+		}
+
+		rewrites = append(rewrites, ReferenceRewrite{From: reference, To: rewritten})
+		return rewritten
+	})
+
+	return rewrittenText, rewrites
+}
+
+// rewriteRunReferences rewrites the references of the issues created or updated by the run and not rewritten yet,
+// as recorded in the ledger so an interrupted run rewrites them when resumed. Only the comments migrated by the run
+// are rewritten, the older ones were rewritten by the run that migrated them.
+func (s *migrator) rewriteRunReferences() error {
+	issues := s.ledger.EntriesWithItemTarget(StepReferences, s.runID)
+	if len(issues) == 0 {
+		return nil
+	}
+
+	audit, err := os.OpenFile(s.referenceAuditPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not open reference audit")
+	}
+	defer audit.Close()
+
+	encoder := json.NewEncoder(audit)
+	var rewritten int
+
+	for _, issue := range issues {
+		// Issues forgotten after their markers were recorded have no target left to rewrite
+		if issue.TargetKey == "" {
+			continue
+		}
+
+		rewrites, err := s.rewriteTargetReferences(issue)
+		if err != nil {
+			log.Printf("Could not rewrite the references of %s: %s", issue.TargetKey, err)
+		}
+
+		for _, rewrite := range rewrites {
+			rewrite.RunID = s.runID
+			rewrite.Time = time.Now().UTC()
+			if err := encoder.Encode(rewrite); err != nil {
+				return errors.Wrap(err, "could not write reference audit")
+			}
+		}
+
+		rewritten += len(rewrites)
+	}
+
+	if rewritten > 0 {
+		log.Printf("%d issue references rewritten in %d issues, see %s", rewritten, len(issues), s.referenceAuditPath)
+	}
+
+	return nil
+}
+
+// rewriteTargetReferences rewrites the items of the issue pending for the run, recording each one as done once rewritten
+func (s *migrator) rewriteTargetReferences(issue LedgerEntry) ([]ReferenceRewrite, error) {
+	sourceKey, targetKey := issue.SourceKey, issue.TargetKey
+	pending := issue.Items[StepReferences]
+
+	targetIssue, err := s.getTargetIssueByKey(targetKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var rewrites []ReferenceRewrite
+	addRewrites := func(field, commentID string, fieldRewrites []ReferenceRewrite) {
+		for _, rewrite := range fieldRewrites {
+			rewrite.TargetKey = targetKey
+			rewrite.SourceKey = sourceKey
+			rewrite.Field = field
+			rewrite.CommentID = commentID
+			rewrites = append(rewrites, rewrite)
+		}
+	}
+
+	if pending[referencesIssueItem] == s.runID {
+		if err := s.rewriteIssueReferences(targetIssue, sourceKey, addRewrites); err != nil {
+			return rewrites, err
+		}
+
+		if err := s.ledger.SetItem(sourceKey, StepReferences, referencesIssueItem, StepStatusDone); err != nil {
+			return rewrites, err
+		}
+	}
+
+	seen := map[string]bool{}
+	isPending := func(commentID string) bool {
+		seen[commentID] = true
+		return pending[commentID] == s.runID
+	}
+
+	if s.adf {
+		err = s.rewriteCommentDocumentReferences(targetIssue, sourceKey, isPending, addRewrites)
+	} else {
+		err = s.rewriteCommentReferences(targetIssue, sourceKey, isPending, addRewrites)
+	}
+	if err != nil {
+		return rewrites, err
+	}
+
+	// Comments deleted from the target are not looked for again
+	for commentID, runID := range pending {
+		if commentID != referencesIssueItem && runID == s.runID && !seen[commentID] {
+			if err := s.ledger.SetItem(sourceKey, StepReferences, commentID, StepStatusDone); err != nil {
+				return rewrites, err
+			}
+		}
+	}
+
+	return rewrites, nil
+}
+
+// rewriteIssueReferences rewrites the summary and description of the target issue
+func (s *migrator) rewriteIssueReferences(targetIssue *jira.Issue, sourceKey string, addRewrites func(field, commentID string, rewrites []ReferenceRewrite)) error {
+	targetKey := targetIssue.Key
+	fields := map[string]interface{}{}
+
+	if summary, summaryRewrites := s.rewriteIssueKeys(targetIssue.Fields.Summary, sourceKey); len(summaryRewrites) > 0 {
+		fields["summary"] = summary
+		addRewrites("summary", "", summaryRewrites)
+	}
+
+	if s.adf {
+		descriptionRewrites, err := s.rewriteDescriptionDocumentReferences(targetKey, sourceKey)
+		if err != nil {
+			return err
+		}
+		addRewrites("description", "", descriptionRewrites)
+	} else if description, descriptionRewrites := s.rewriteIssueKeys(targetIssue.Fields.Description, sourceKey); len(descriptionRewrites) > 0 {
+		fields["description"] = description
+		addRewrites("description", "", descriptionRewrites)
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	response, err := s.targetClient.Issue.UpdateIssue(targetKey, map[string]interface{}{"fields": fields})
+	if err != nil {
+		return parseResponseError("UpdateIssue", response, err)
+	}
+	response.Body.Close()
+
+	return nil
+}
+
+func (s *migrator) rewriteCommentReferences(targetIssue *jira.Issue, sourceKey string, isPending func(commentID string) bool, addRewrites func(field, commentID string, rewrites []ReferenceRewrite)) error {
+	comments, err := getComments(s.targetClient, targetIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	for _, comment := range comments {
+		if !isPending(comment.ID) {
+			continue
+		}
+
+		if body, commentRewrites := s.rewriteIssueKeys(comment.Body, sourceKey); len(commentRewrites) > 0 {
+			_, response, err := s.targetClient.Issue.UpdateComment(targetIssue.ID, &jira.Comment{ID: comment.ID, Body: body})
+			if err != nil {
+				return parseResponseError("UpdateComment", response, err)
+			}
+
+			addRewrites("comment", comment.ID, commentRewrites)
+		}
+
+		if err := s.ledger.SetItem(sourceKey, StepReferences, comment.ID, StepStatusDone); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *migrator) rewriteDescriptionDocumentReferences(targetKey, sourceKey string) ([]ReferenceRewrite, error) {
+	document, err := getDescriptionDocument(s.targetClient, targetKey)
+	if err != nil || document == nil {
+		return nil, err
+	}
+
+	var rewrites []ReferenceRewrite
+	rewriteDocumentText(document, func(text string) string {
+		rewritten, textRewrites := s.rewriteIssueKeys(text, sourceKey)
+		rewrites = append(rewrites, textRewrites...)
+		return rewritten
+	})
+
+	if len(rewrites) == 0 {
+		return nil, nil
+	}
+
+	return rewrites, setDescriptionDocument(s.targetClient, targetKey, document)
+}
+
+func (s *migrator) rewriteCommentDocumentReferences(targetIssue *jira.Issue, sourceKey string, isPending func(commentID string) bool, addRewrites func(field, commentID string, rewrites []ReferenceRewrite)) error {
+	comments, err := getCommentDocuments(s.targetClient, targetIssue.Key)
+	if err != nil {
+		return err
+	}
+
+	for _, comment := range comments {
+		if !isPending(comment.ID) {
+			continue
+		}
+
+		var commentRewrites []ReferenceRewrite
+		if comment.Body != nil {
+			rewriteDocumentText(comment.Body, func(text string) string {
+				rewritten, textRewrites := s.rewriteIssueKeys(text, sourceKey)
+				commentRewrites = append(commentRewrites, textRewrites...)
+				return rewritten
+			})
+		}
+
+		if len(commentRewrites) > 0 {
+			if err := updateCommentDocument(s.targetClient, targetIssue.ID, comment.ID, comment.Body); err != nil {
+				return err
+			}
+
+			addRewrites("comment", comment.ID, commentRewrites)
+		}
+
+		if err := s.ledger.SetItem(sourceKey, StepReferences, comment.ID, StepStatusDone); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package migration
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/natenho/go-jira"
+	"github.com/pkg/errors"
+)
+
+// RollbackAction is the deletion of an object created by a run
+type RollbackAction struct {
+	Object LedgerObject
+	Error  error
+}
+
+func (a RollbackAction) String() string {
+	if a.Error != nil {
+		return fmt.Sprintf("%s: %s", a.Object, a.Error)
+	}
+
+	return a.Object.String()
+}
+
+// Rollback deletes everything the run created on the target, in dependency order.
+// When dryRun is set, the actions are returned without deleting anything.
+func (s *migrator) Rollback(runID string, dryRun bool) ([]RollbackAction, error) {
+	objects := s.ledger.RunObjects(runID)
+	if len(objects) == 0 {
+		return nil, errors.Errorf("nothing recorded for run %s", runID)
+	}
+
+	actions := getRollbackActions(objects)
+	if dryRun {
+		return actions, nil
+	}
+
+	for i := range actions {
+		actions[i].Error = s.rollbackObject(actions[i].Object)
+	}
+
+	return actions, nil
+}
+
+// getRollbackActions orders the deletions: links of surviving issues first, then subtasks before their parents, then sprints
+func getRollbackActions(objects []LedgerObject) []RollbackAction {
+	deletedIssues := map[string]LedgerObject{}
+	for _, object := range objects {
+		if object.Kind == ObjectIssue {
+			deletedIssues[object.ID] = object
+		}
+	}
+
+	var links, issues, sprints []LedgerObject
+
+	for _, object := range objects {
+		switch object.Kind {
+		case ObjectRemoteLink:
+			if _, ok := deletedIssues[object.IssueKey]; !ok {
+				links = append(links, object)
+			}
+		case ObjectIssueLink:
+			_, inwardDeleted := deletedIssues[object.IssueKey]
+			_, outwardDeleted := deletedIssues[object.LinkedKey]
+			if !inwardDeleted && !outwardDeleted {
+				links = append(links, object)
+			}
+		case ObjectIssue:
+			issues = append(issues, object)
+		case ObjectSprint:
+			sprints = append(sprints, object)
+		}
+	}
+
+	depth := func(issue LedgerObject) int {
+		var depth int
+		for parent, ok := deletedIssues[issue.ParentKey]; ok && depth < len(deletedIssues); parent, ok = deletedIssues[parent.ParentKey] {
+			depth++
+		}
+		return depth
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return depth(issues[i]) > depth(issues[j])
+	})
+
+	var actions []RollbackAction
+	for _, group := range [][]LedgerObject{links, issues, sprints} {
+		for _, object := range group {
+			actions = append(actions, RollbackAction{Object: object})
+		}
+	}
+
+	return actions
+}
+
+func (s *migrator) rollbackObject(object LedgerObject) error {
+	var err error
+
+	switch object.Kind {
+	case ObjectIssue:
+		err = s.deleteTargetIssue(object)
+	case ObjectSprint:
+		err = s.deleteTarget("Sprint.Delete", fmt.Sprintf("rest/agile/1.0/sprint/%s", object.ID))
+	case ObjectRemoteLink:
+		err = s.deleteTarget("DeleteRemoteLink", fmt.Sprintf("rest/api/2/issue/%s/remotelink/%s", object.IssueKey, object.ID))
+	case ObjectIssueLink:
+		err = s.deleteTargetIssueLink(object)
+	default:
+		err = errors.Errorf("unknown object kind %s", object.Kind)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return s.ledger.RemoveObject(object)
+}
+
+func (s *migrator) deleteTargetIssue(object LedgerObject) error {
+	response, err := s.targetClient.Issue.Delete(object.ID)
+	if err = parseResponseError("Delete", response, err); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if targetKey, ok := s.ledger.TargetKey(object.SourceKey); ok && targetKey == object.ID {
+		return s.ledger.Forget(object.SourceKey)
+	}
+
+	return nil
+}
+
+func (s *migrator) deleteTargetIssueLink(object LedgerObject) error {
+	issue, response, err := s.targetClient.Issue.Get(object.IssueKey, &jira.GetQueryOptions{Fields: "issuelinks"})
+	if err != nil {
+		return parseResponseError("Get", response, err)
+	}
+
+	for _, link := range issue.Fields.IssueLinks {
+		if link.Type.Name != object.LinkType || link.OutwardIssue == nil || link.OutwardIssue.Key != object.LinkedKey {
+			continue
+		}
+
+		response, err := s.targetClient.Issue.DeleteLink(link.ID)
+		if err != nil {
+			return parseResponseError("DeleteLink", response, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *migrator) deleteTarget(operation, apiEndpoint string) error {
+	request, err := s.targetClient.NewRequest(http.MethodDelete, apiEndpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.targetClient.Do(request, nil)
+	if err = parseResponseError(operation, response, err); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
@@ -2,6 +2,8 @@ package migration
 
 import (
 	"log"
+	"strconv"
+	"strings"
 
 	"github.com/natenho/go-jira"
 	"github.com/natenho/go-jira-migrate/internal"
@@ -42,16 +44,43 @@ func (s *migrator) migrateOpenSprints(sourceBoardID, targetBoardID int) error {
 		return nil
 	}
 
-	sourceSprints, err := getOpenSprints(s.sourceClient, sourceBoardID)
+	missingSprints, err := s.mapOpenSprints(sourceBoardID, targetBoardID)
 	if err != nil {
 		return err
 	}
 
+	for _, sourceSprint := range missingSprints {
+		createdSprint, response, err := s.targetClient.Sprint.Create(&jira.Sprint{Name: sourceSprint.Name, OriginBoardID: targetBoardID})
+		if err != nil {
+			return parseResponseError("Sprint.Create", response, err)
+		}
+
+		s.sourceTargetSprintMap[sourceSprint.ID] = createdSprint
+
+		if err := s.recordObject(LedgerObject{Kind: ObjectSprint, ID: strconv.Itoa(createdSprint.ID)}); err != nil {
+			return err
+		}
+
+		log.Printf("Created sprint %s", sourceSprint.Name)
+	}
+
+	return nil
+}
+
+// mapOpenSprints maps the open source sprints to the target sprints with the same name, returning the ones missing on target
+func (s *migrator) mapOpenSprints(sourceBoardID, targetBoardID int) ([]jira.Sprint, error) {
+	sourceSprints, err := getOpenSprints(s.sourceClient, sourceBoardID)
+	if err != nil {
+		return nil, err
+	}
+
 	targetSprints, err := getOpenSprints(s.targetClient, targetBoardID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var missingSprints []jira.Sprint
+
 	for _, sourceSprint := range sourceSprints {
 		targetSprint, targetSprintFound := internal.SliceFind(targetSprints, func(targetSprint jira.Sprint) bool {
 			return targetSprint.Name == sourceSprint.Name
@@ -62,57 +91,106 @@ func (s *migrator) migrateOpenSprints(sourceBoardID, targetBoardID int) error {
 			continue
 		}
 
-		createdSprint, response, err := s.targetClient.Sprint.Create(&jira.Sprint{Name: sourceSprint.Name, OriginBoardID: targetBoardID})
-		if err != nil {
-			return parseResponseError("Sprint.Create", response, err)
-		}
+		missingSprints = append(missingSprints, sourceSprint)
+	}
 
-		s.sourceTargetSprintMap[sourceSprint.ID] = createdSprint
+	return missingSprints, nil
+}
 
-		log.Printf("Created sprint %s", sourceSprint.Name)
+func (s *migrator) setupTargetSprint(sourceIssue *jira.Issue, targetIssue *jira.Issue) error {
+	openSourceSprint, err := getOpenSprint(s.getCustomFieldValue(sourceIssue, "Sprint"))
+	if err != nil {
+		return err
+	}
+
+	rawSourceSprintID, ok := openSourceSprint["id"]
+	if !ok {
+		return nil
+	}
+
+	sourceSprintID := int(rawSourceSprintID.(float64))
+	targetSprint, ok := s.sourceTargetSprintMap[sourceSprintID]
+	if !ok {
+		return errors.Errorf("Target sprint '%s' not found", openSourceSprint["name"])
+	}
+
+	if response, err := s.targetClient.Sprint.MoveIssuesToSprint(targetSprint.ID, []string{targetIssue.ID}); err != nil {
+		return parseResponseError("MoveIssuesToSprint", response, err)
 	}
 
 	return nil
 }
 
-func (s *migrator) setupTargetSprint(sourceIssue *jira.Issue, targetIssue *jira.Issue) error {
-	rawSourceFieldValue, ok := s.getCustomFieldValue(sourceIssue, "Sprint").([]interface{})
-	if !ok || len(rawSourceFieldValue) == 0 {
-		return nil
+// getOpenSprint returns the active sprint of a sprint field value, or the last future one
+func getOpenSprint(rawFieldValue interface{}) (map[string]interface{}, error) {
+	rawSprints, ok := rawFieldValue.([]interface{})
+	if !ok || len(rawSprints) == 0 {
+		return nil, nil
 	}
 
-	var openSourceSprint map[string]interface{}
+	var openSprint map[string]interface{}
+
+	for _, rawSprint := range rawSprints {
+		sprint, ok := rawSprint.(map[string]interface{})
+		if description, isString := rawSprint.(string); isString {
+			sprint, ok = parseServerSprint(description)
+		}
 
-	for _, rawSourceSprint := range rawSourceFieldValue {
-		sourceSprint, ok := rawSourceSprint.(map[string]interface{})
 		if !ok {
-			return errors.Errorf("Could not parse source sprint")
+			return nil, errors.Errorf("Could not parse source sprint")
 		}
 
-		if sourceSprint["state"] == "future" {
-			openSourceSprint = sourceSprint
+		if sprint["state"] == "future" {
+			openSprint = sprint
 		}
 
-		if sourceSprint["state"] == "active" {
-			openSourceSprint = sourceSprint
+		if sprint["state"] == "active" {
+			openSprint = sprint
 			break
 		}
 	}
 
-	rawSourceSprintID, ok := openSourceSprint["id"]
-	if !ok {
-		return nil
-	}
+	return openSprint, nil
+}
 
-	sourceSprintID := int(rawSourceSprintID.(float64))
-	targetSprint, ok := s.sourceTargetSprintMap[sourceSprintID]
-	if !ok {
-		return errors.Errorf("Target sprint '%s' not found", openSourceSprint["name"])
+// parseServerSprint reads the sprint field value of Jira Server, a description such as
+// com.atlassian.greenhopper.service.sprint.Sprint@1f39[id=1,rapidViewId=2,state=ACTIVE,name=Sprint 1,...]
+func parseServerSprint(description string) (map[string]interface{}, bool) {
+	start := strings.Index(description, "[")
+	end := strings.LastIndex(description, "]")
+	if start < 0 || end < start {
+		return nil, false
 	}
 
-	if response, err := s.targetClient.Sprint.MoveIssuesToSprint(targetSprint.ID, []string{targetIssue.ID}); err != nil {
-		return parseResponseError("MoveIssuesToSprint", response, err)
+	sprint := map[string]interface{}{}
+	var last string
+
+	for _, attribute := range strings.Split(description[start+1:end], ",") {
+		name, value, ok := strings.Cut(attribute, "=")
+		if !ok {
+			// A comma within the sprint name
+			if last == "name" {
+				sprint["name"] = sprint["name"].(string) + "," + attribute
+			}
+			continue
+		}
+
+		last = name
+
+		switch name {
+		case "id":
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, false
+			}
+			sprint["id"] = float64(id)
+		case "state":
+			sprint["state"] = strings.ToLower(value)
+		case "name":
+			sprint["name"] = value
+		}
 	}
 
-	return nil
+	_, ok := sprint["id"]
+	return sprint, ok
 }
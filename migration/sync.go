@@ -0,0 +1,85 @@
+package migration
+
+import (
+	"github.com/natenho/go-jira"
+)
+
+// updateIssue brings an issue already migrated up to date with its source issue, migrating only what is new
+func (s *migrator) updateIssue(result *Result, sourceIssue, targetIssue *jira.Issue, entry LedgerEntry) {
+	s.recordStep(result, StepFields, s.updateFields(sourceIssue, targetIssue))
+
+	// Comments, attachments and links already migrated are skipped by their steps
+	// The description document is set by the fields update
+	skipped := LedgerEntry{Steps: map[string]string{StepCreate: StepStatusDone, StepDescription: StepStatusDone}}
+	if entry.IsStepDone(StepRemoteLink) {
+		skipped.Steps[StepRemoteLink] = StepStatusDone
+	}
+
+	if s.hasTargetStatus(sourceIssue, targetIssue) {
+		skipped.Steps[StepStatus] = StepStatusDone
+	}
+
+	s.migrateSteps(result, sourceIssue, targetIssue, skipped)
+}
+
+// updateFields overwrites the target fields with the values a new migration of the source issue would set
+func (s *migrator) updateFields(sourceIssue, targetIssue *jira.Issue) error {
+	updatedIssue, err := s.buildTargetIssue(sourceIssue)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"summary": updatedIssue.Fields.Summary,
+		"labels":  updatedIssue.Fields.Labels,
+	}
+
+	// The description document is set once the other fields are updated
+	if !s.adf {
+		fields["description"] = updatedIssue.Fields.Description
+	}
+
+	if updatedIssue.Fields.Priority != nil {
+		fields["priority"] = updatedIssue.Fields.Priority
+	}
+
+	if updatedIssue.Fields.Assignee != nil {
+		fields["assignee"] = updatedIssue.Fields.Assignee
+	} else if sourceIssue.Fields.Assignee == nil {
+		fields["assignee"] = nil
+	}
+
+	if updatedIssue.Fields.Reporter != nil {
+		fields["reporter"] = updatedIssue.Fields.Reporter
+	}
+
+	for key, value := range updatedIssue.Fields.Unknowns {
+		fields[key] = value
+	}
+
+	// A field missing from the edit screen of the target issue would reject the whole update
+	editMeta, response, err := s.targetClient.Issue.GetEditMeta(targetIssue)
+	if err != nil {
+		return parseResponseError("GetEditMeta", response, err)
+	}
+
+	for key := range fields {
+		if _, ok := editMeta.Fields[key]; !ok {
+			delete(fields, key)
+		}
+	}
+
+	if len(fields) > 0 {
+		response, err := s.targetClient.Issue.UpdateIssue(targetIssue.Key, map[string]interface{}{"fields": fields})
+		if err != nil {
+			return parseResponseError("UpdateIssue", response, err)
+		}
+		response.Body.Close()
+	}
+
+	if s.adf {
+		return s.migrateDescriptionDocument(sourceIssue, updatedIssue, targetIssue.Key)
+	}
+
+	return nil
+}
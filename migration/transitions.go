@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/natenho/go-jira"
+	"golang.org/x/exp/slices"
 )
 
 func (s *migrator) migrateStatus(sourceIssue *jira.Issue, targetIssue *jira.Issue) chan error {
@@ -24,8 +25,16 @@ func (s *migrator) migrateStatus(sourceIssue *jira.Issue, targetIssue *jira.Issu
 		return errChan
 	}
 
+	targetStatusName, mapped := s.statusMappings[strings.ToLower(sourceIssue.Fields.Status.Name)]
+	if mapped && slices.IndexFunc(targetTransitions, func(targetTransition jira.Transition) bool {
+		return strings.EqualFold(targetTransition.To.Name, targetStatusName)
+	}) < 0 {
+		mapped = false
+	}
+
 	for _, targetTransition := range targetTransitions {
-		if strings.EqualFold(targetTransition.To.StatusCategory.Name, sourceIssue.Fields.Status.StatusCategory.Name) {
+		if (mapped && strings.EqualFold(targetTransition.To.Name, targetStatusName)) ||
+			(!mapped && strings.EqualFold(targetTransition.To.StatusCategory.Name, sourceIssue.Fields.Status.StatusCategory.Name)) {
 			if response, err := s.targetClient.Issue.DoTransition(targetIssue.Key, targetTransition.ID); err != nil {
 				wg.Add(1)
 				go func() {
@@ -40,3 +49,16 @@ func (s *migrator) migrateStatus(sourceIssue *jira.Issue, targetIssue *jira.Issu
 	wg.Wait()
 	return errChan
 }
+
+// hasTargetStatus tells whether the target issue is already in the status the source issue would be transitioned to
+func (s *migrator) hasTargetStatus(sourceIssue *jira.Issue, targetIssue *jira.Issue) bool {
+	if targetIssue.Fields.Status == nil {
+		return false
+	}
+
+	if targetStatusName, mapped := s.statusMappings[strings.ToLower(sourceIssue.Fields.Status.Name)]; mapped {
+		return strings.EqualFold(targetIssue.Fields.Status.Name, targetStatusName)
+	}
+
+	return strings.EqualFold(targetIssue.Fields.Status.StatusCategory.Key, sourceIssue.Fields.Status.StatusCategory.Key)
+}
@@ -0,0 +1,223 @@
+package migration
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultRequestsPerSecond = 10
+	DefaultMaxRetries        = 8
+	minRetryBackoff          = time.Second
+	maxRetryBackoff          = time.Minute
+)
+
+// Transport paces the requests of every worker with a token bucket per host, and retries the requests throttled by
+// Jira, waiting as long as Retry-After and X-RateLimit-* headers ask or with jittered exponential backoff.
+// Migrators sharing a transport share its rate.
+type Transport struct {
+	// Counters read by the adaptive concurrency, first to be 64-bit aligned
+	requests  int64
+	latency   int64
+	throttled int64
+
+	next              http.RoundTripper
+	requestsPerSecond float64
+	maxRetries        int
+
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// NewTransport creates a transport limited to the requests per second to each instance (zero means no limit),
+// retrying the throttled requests up to maxRetries times
+func NewTransport(requestsPerSecond float64, maxRetries int) *Transport {
+	return &Transport{
+		next:              http.DefaultTransport,
+		requestsPerSecond: requestsPerSecond,
+		maxRetries:        maxRetries,
+		buckets:           map[string]*tokenBucket{},
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	bucket := t.bucket(req.URL.Host)
+
+	for attempt := 0; ; attempt++ {
+		if err := sleep(req, bucket.take()); err != nil {
+			return nil, err
+		}
+
+		startedAt := time.Now()
+		response, err := t.next.RoundTrip(req)
+		if err != nil {
+			return response, err
+		}
+
+		atomic.AddInt64(&t.requests, 1)
+		atomic.AddInt64(&t.latency, int64(time.Since(startedAt)))
+
+		bucket.observe(response)
+
+		if !isThrottled(response) {
+			return response, nil
+		}
+
+		atomic.AddInt64(&t.throttled, 1)
+
+		if attempt >= t.maxRetries || (req.Body != nil && req.GetBody == nil) {
+			return response, nil
+		}
+
+		delay := retryBackoff(attempt)
+		if retryAfter := getRetryAfter(response); retryAfter > delay {
+			delay = retryAfter
+		}
+
+		// Every worker waits, as the limit applies to all requests to the host
+		bucket.pause(delay)
+
+		log.Printf("%s %s answered %s, retrying in %s (attempt %d of %d)", req.Method, req.URL.Path, response.Status, delay.Round(time.Millisecond), attempt+1, t.maxRetries)
+
+		response.Body.Close()
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// stats returns the requests made, their total latency and how many were throttled since the last call
+func (t *Transport) stats() (requests int64, latency time.Duration, throttled int64) {
+	return atomic.SwapInt64(&t.requests, 0), time.Duration(atomic.SwapInt64(&t.latency, 0)), atomic.SwapInt64(&t.throttled, 0)
+}
+
+func (t *Transport) bucket(host string) *tokenBucket {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	bucket, ok := t.buckets[host]
+	if !ok {
+		bucket = newTokenBucket(t.requestsPerSecond)
+		t.buckets[host] = bucket
+	}
+
+	return bucket
+}
+
+func isThrottled(response *http.Response) bool {
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable
+}
+
+// retryBackoff doubles the wait after each attempt, randomized so workers throttled together do not retry together
+func retryBackoff(attempt int) time.Duration {
+	backoff := time.Duration(float64(minRetryBackoff) * math.Pow(2, float64(attempt)))
+	if backoff > maxRetryBackoff || backoff <= 0 {
+		backoff = maxRetryBackoff
+	}
+
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// getRetryAfter reads the Retry-After header, in seconds or as an HTTP date
+func getRetryAfter(response *http.Response) time.Duration {
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
+
+func sleep(req *http.Request, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// tokenBucket allows a steady rate of requests with bursts of up to one second of requests
+type tokenBucket struct {
+	mutex       sync.Mutex
+	rate        float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// take reserves a token, returning how long to wait before using it
+func (b *tokenBucket) take() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	if b.rate <= 0 {
+		return b.pausedUntil.Sub(now)
+	}
+
+	b.tokens = math.Min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	if paused := b.pausedUntil.Sub(now); paused > delay {
+		delay = paused
+	}
+
+	return delay
+}
+
+// pause holds every request to the host for the given time
+func (b *tokenBucket) pause(delay time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if until := time.Now().Add(delay); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+// observe pauses the requests until the rate limit resets when the response tells no request is left
+func (b *tokenBucket) observe(response *http.Response) {
+	remaining, err := strconv.Atoi(response.Header.Get("X-RateLimit-Remaining"))
+	if err != nil || remaining > 0 {
+		return
+	}
+
+	if reset, err := time.Parse(time.RFC3339, response.Header.Get("X-RateLimit-Reset")); err == nil {
+		b.pause(time.Until(reset))
+	}
+}
@@ -0,0 +1,224 @@
+package migration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/natenho/go-jira"
+)
+
+// Ways of finding the target user of a source user that is not mapped explicitly
+const (
+	MatchUsersByEmail       = "email"
+	MatchUsersByDisplayName = "displayName"
+)
+
+// UnmappedUser is a source user without an active target user, replaced by the migration user or mentioned by name
+type UnmappedUser struct {
+	ID           string `json:"id"`
+	DisplayName  string `json:"displayName,omitempty"`
+	EmailAddress string `json:"emailAddress,omitempty"`
+	Reason       string `json:"reason"`
+}
+
+// userDirectory caches the mentioned source users, the target users found for the source users, and the source users without one
+type userDirectory struct {
+	mutex       sync.Mutex
+	sourceUsers map[string]*jira.User
+	targetUsers map[string]*jira.User
+	unmapped    map[string]UnmappedUser
+}
+
+func newUserDirectory() *userDirectory {
+	return &userDirectory{
+		sourceUsers: map[string]*jira.User{},
+		targetUsers: map[string]*jira.User{},
+		unmapped:    map[string]UnmappedUser{},
+	}
+}
+
+// WithUserMatching finds the target users of the source users not mapped explicitly by email address or display name,
+// trying each way in order
+func WithUserMatching(matchBy ...string) Option {
+	return func(m *migrator) {
+		m.userMatching = append(m.userMatching, matchBy...)
+	}
+}
+
+func (s *migrator) canSetAssignee(sourceIssue *jira.Issue) bool {
+	return s.isActiveTargetUser(sourceIssue.Fields.Assignee)
+}
+
+func (s *migrator) canSetReporter(sourceIssue *jira.Issue) bool {
+	return s.isActiveTargetUser(sourceIssue.Fields.Reporter)
+}
+
+// isActiveTargetUser tells whether the target user of a source user can be assigned, otherwise it is reported as unmapped
+func (s *migrator) isActiveTargetUser(sourceUser *jira.User) bool {
+	if sourceUser == nil {
+		return false
+	}
+
+	targetUser := s.getTargetUser(sourceUser)
+	if targetUser != nil && !targetUser.Active {
+		s.addUnmappedUser(sourceUser, "inactive or not found on target")
+	}
+
+	return targetUser != nil && targetUser.Active
+}
+
+// mapUser returns a reference to the target user of a source user, or nil when there is none
+func (s *migrator) mapUser(sourceUser *jira.User) *jira.User {
+	targetUser := s.getTargetUser(sourceUser)
+	if targetUser == nil {
+		return nil
+	}
+
+	return s.targetInstance.newUser(userID(targetUser))
+}
+
+// mention returns the wiki markup mentioning the target user of a source user, or its name when not found on target
+func (s *migrator) mention(sourceUser *jira.User) string {
+	if sourceUser == nil {
+		return "Anonymous"
+	}
+
+	targetUser := s.mapUser(sourceUser)
+	if targetUser == nil {
+		if sourceUser.DisplayName == "" {
+			return userID(sourceUser)
+		}
+		return sourceUser.DisplayName
+	}
+
+	if s.targetInstance.isCloud() {
+		return fmt.Sprintf("[~accountid:%s]", targetUser.AccountID)
+	}
+
+	return fmt.Sprintf("[~%s]", targetUser.Name)
+}
+
+// getTargetUser finds the target user of a source user, in order: the user mapping, the same user when both
+// instances are Cloud or both are Server, then the users matched by email address or display name
+func (s *migrator) getTargetUser(sourceUser *jira.User) *jira.User {
+	sourceID := userID(sourceUser)
+
+	s.users.mutex.Lock()
+	targetUser, ok := s.users.targetUsers[sourceID]
+	s.users.mutex.Unlock()
+
+	if ok {
+		return targetUser
+	}
+
+	targetUser, reason := s.findTargetUser(sourceUser)
+
+	s.users.mutex.Lock()
+	s.users.targetUsers[sourceID] = targetUser
+	s.users.mutex.Unlock()
+
+	if targetUser == nil {
+		s.addUnmappedUser(sourceUser, reason)
+	}
+
+	return targetUser
+}
+
+func (s *migrator) findTargetUser(sourceUser *jira.User) (*jira.User, string) {
+	sourceID := userID(sourceUser)
+
+	if targetID, ok := s.userMappings[sourceID]; ok {
+		// Mapped users are mentioned even when they cannot be read, and are not assigned
+		targetUser, err := s.targetInstance.getUser(s.targetClient, targetID)
+		if err != nil {
+			return s.targetInstance.newUser(targetID), ""
+		}
+
+		return targetUser, ""
+	}
+
+	if s.sourceInstance.isCloud() == s.targetInstance.isCloud() {
+		if targetUser, err := s.targetInstance.getUser(s.targetClient, sourceID); err == nil {
+			return targetUser, ""
+		}
+	}
+
+	reason := "not found on target"
+
+	for _, matchBy := range s.userMatching {
+		var value string
+		switch matchBy {
+		case MatchUsersByEmail:
+			value = sourceUser.EmailAddress
+		case MatchUsersByDisplayName:
+			value = sourceUser.DisplayName
+		}
+
+		if value == "" {
+			continue
+		}
+
+		candidates, err := s.targetInstance.findUsers(s.targetClient, value)
+		if err != nil {
+			reason = fmt.Sprintf("could not search target users: %s", err)
+			continue
+		}
+
+		var matches []jira.User
+		for _, candidate := range candidates {
+			if (matchBy == MatchUsersByEmail && strings.EqualFold(candidate.EmailAddress, value)) ||
+				(matchBy == MatchUsersByDisplayName && strings.EqualFold(candidate.DisplayName, value)) {
+				matches = append(matches, candidate)
+			}
+		}
+
+		if len(matches) == 1 {
+			return &matches[0], ""
+		}
+
+		if len(matches) > 1 {
+			reason = fmt.Sprintf("%d target users with the same %s", len(matches), matchBy)
+		}
+	}
+
+	return nil, reason
+}
+
+func (s *migrator) addUnmappedUser(sourceUser *jira.User, reason string) {
+	s.users.mutex.Lock()
+	defer s.users.mutex.Unlock()
+
+	sourceID := userID(sourceUser)
+	if _, ok := s.users.unmapped[sourceID]; ok {
+		return
+	}
+
+	s.users.unmapped[sourceID] = UnmappedUser{
+		ID:           sourceID,
+		DisplayName:  sourceUser.DisplayName,
+		EmailAddress: sourceUser.EmailAddress,
+		Reason:       reason,
+	}
+}
+
+// UnmappedUsers returns the source users found so far without an active target user, sorted by name
+func (s *migrator) UnmappedUsers() []UnmappedUser {
+	s.users.mutex.Lock()
+	defer s.users.mutex.Unlock()
+
+	users := make([]UnmappedUser, 0, len(s.users.unmapped))
+	for _, user := range s.users.unmapped {
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].DisplayName != users[j].DisplayName {
+			return users[i].DisplayName < users[j].DisplayName
+		}
+		return users[i].ID < users[j].ID
+	})
+
+	return users
+}
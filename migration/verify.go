@@ -0,0 +1,483 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/natenho/go-jira"
+	"github.com/natenho/go-jira-migrate/internal"
+	"github.com/pkg/errors"
+)
+
+// Verification is the comparison of a source issue with the target issue it was migrated to
+type Verification struct {
+	SourceKey   string
+	TargetKey   string
+	Differences []string
+	Errors      []error
+}
+
+func (v Verification) OK() bool {
+	return len(v.Differences) == 0 && len(v.Errors) == 0
+}
+
+func (v Verification) String() string {
+	if v.OK() {
+		return fmt.Sprintf("%s -> %s: OK", v.SourceKey, v.TargetKey)
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s -> %s: %d differences", v.SourceKey, v.TargetKey, len(v.Differences))
+
+	for _, difference := range v.Differences {
+		fmt.Fprintf(&builder, "\n  %s", difference)
+	}
+
+	for _, err := range v.Errors {
+		fmt.Fprintf(&builder, "\n  error: %s", err)
+	}
+
+	return builder.String()
+}
+
+func (v *Verification) differ(format string, args ...interface{}) {
+	v.Differences = append(v.Differences, fmt.Sprintf(format, args...))
+}
+
+// Verify pairs the source issues selected by the query with the target issues linked to them, and compares them
+func (s *migrator) Verify(jql string) (chan Verification, error) {
+	verifications := make(chan Verification)
+
+	if _, _, err := s.prepare(); err != nil {
+		close(verifications)
+		return verifications, err
+	}
+
+	sourceTargetKeys := map[string][]string{}
+	err := s.findMigratedIssues(func(sourceKey string, targetIssue jira.Issue) error {
+		sourceTargetKeys[sourceKey] = append(sourceTargetKeys[sourceKey], targetIssue.Key)
+		return nil
+	})
+	if err != nil {
+		close(verifications)
+		return verifications, err
+	}
+
+	jql = internal.SanitizeJQL(s.sourceProjectKey, jql)
+	options := &jira.SearchOptions{
+		MaxResults: maxResultsPerSearch,
+		Fields:     []string{"key"}}
+
+	var sourceKeys []string
+	err = s.sourceClient.Issue.SearchPages(jql, options, func(issue jira.Issue) error {
+		sourceKeys = append(sourceKeys, issue.Key)
+		return nil
+	})
+	if err != nil {
+		close(verifications)
+		return verifications, err
+	}
+
+	pendingKeys := make(chan string, s.workerPoolSize)
+	workers := &sync.WaitGroup{}
+
+	for i := 0; i < len(sourceKeys) && i < s.workerPoolSize; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for sourceKey := range pendingKeys {
+				verifications <- s.verifyIssue(sourceKey, sourceTargetKeys)
+			}
+		}()
+	}
+
+	go func() {
+		for _, sourceKey := range sourceKeys {
+			pendingKeys <- sourceKey
+		}
+
+		close(pendingKeys)
+		workers.Wait()
+		close(verifications)
+	}()
+
+	return verifications, nil
+}
+
+func (s *migrator) verifyIssue(sourceKey string, sourceTargetKeys map[string][]string) Verification {
+	verification := Verification{SourceKey: sourceKey}
+
+	targetKeys := sourceTargetKeys[sourceKey]
+	if len(targetKeys) == 0 {
+		verification.differ("not migrated")
+		return verification
+	}
+
+	verification.TargetKey = targetKeys[0]
+	if len(targetKeys) > 1 {
+		verification.differ("migrated %d times: %s", len(targetKeys), strings.Join(targetKeys, ", "))
+	}
+
+	sourceIssue, err := s.getSourceIssueByKey(sourceKey)
+	if err != nil {
+		verification.Errors = append(verification.Errors, err)
+		return verification
+	}
+
+	targetIssue, err := s.getTargetIssueByKey(verification.TargetKey)
+	if err != nil {
+		verification.Errors = append(verification.Errors, err)
+		return verification
+	}
+
+	getTargetKey := func(sourceKey string) string {
+		if targetKeys := sourceTargetKeys[sourceKey]; len(targetKeys) > 0 {
+			return targetKeys[0]
+		}
+		return ""
+	}
+
+	targetAttachments := s.getVerifiedAttachments(sourceIssue, targetIssue)
+
+	s.verifyFields(&verification, sourceIssue, targetIssue, targetAttachments)
+	s.verifyComments(&verification, sourceIssue, targetIssue, targetAttachments)
+	s.verifyAttachments(&verification, sourceIssue, targetIssue)
+	s.verifyLinks(&verification, sourceIssue, targetIssue, getTargetKey)
+	s.verifyParent(&verification, sourceIssue, targetIssue, getTargetKey)
+	s.verifySprint(&verification, sourceIssue, targetIssue)
+
+	return verification
+}
+
+func (s *migrator) verifyFields(verification *Verification, sourceIssue, targetIssue *jira.Issue, targetAttachments map[string]*jira.Attachment) {
+	// Attachment links are not rewritten in the summary
+	if expectedSummary := s.expectedText(sourceIssue.Fields.Summary, sourceIssue, nil); expectedSummary != targetIssue.Fields.Summary {
+		verification.differ("summary: expected %q, found %q", expectedSummary, targetIssue.Fields.Summary)
+	}
+
+	sourceDescription := normalizeText(s.expectedText(s.rewriteContent(sourceIssue.Fields.Description), sourceIssue, targetAttachments))
+	if !strings.HasPrefix(normalizeText(targetIssue.Fields.Description), sourceDescription) {
+		verification.differ("description: does not start with the source description")
+	}
+
+	expectedLabels := s.getTargetLabels(sourceIssue.Fields.Labels)
+	targetLabels := append([]string{}, targetIssue.Fields.Labels...)
+	sort.Strings(expectedLabels)
+	sort.Strings(targetLabels)
+	if strings.Join(expectedLabels, ",") != strings.Join(targetLabels, ",") {
+		verification.differ("labels: expected [%s], found [%s]", strings.Join(expectedLabels, ", "), strings.Join(targetLabels, ", "))
+	}
+
+	if sourceIssue.Fields.Priority != nil && s.canMigrateField(targetIssue.Fields.Type.Name, "priority") {
+		if targetIssue.Fields.Priority == nil || targetIssue.Fields.Priority.Name != sourceIssue.Fields.Priority.Name {
+			verification.differ("priority: expected %s, found %s", sourceIssue.Fields.Priority.Name, getPriorityName(targetIssue.Fields.Priority))
+		}
+	}
+
+	if sourceIssue.Fields.Status != nil && targetIssue.Fields.Status != nil &&
+		sourceIssue.Fields.Status.StatusCategory.Key != targetIssue.Fields.Status.StatusCategory.Key {
+		verification.differ("status category: expected %s, found %s", sourceIssue.Fields.Status.StatusCategory.Name, targetIssue.Fields.Status.StatusCategory.Name)
+	}
+
+	for _, targetField := range s.targetFieldPerIssueType[targetIssue.Fields.Type.Name] {
+		if len(s.getSourceFieldsFromTargetFieldKey(targetField.Key)) == 0 {
+			continue
+		}
+
+		// The source fields mapped to the same target field are collapsed as the migration does
+		var expectedValue interface{}
+		if value, ok := s.getTargetFieldValue(sourceIssue, targetField); ok {
+			expectedValue = normalizeFieldValue(value)
+		}
+
+		targetValue := normalizeFieldValue(targetIssue.Fields.Unknowns[targetField.Key])
+		if !reflect.DeepEqual(expectedValue, targetValue) {
+			verification.differ("%s: expected %s, found %s", targetField.Name, formatFieldValue(expectedValue), formatFieldValue(targetValue))
+		}
+	}
+}
+
+func (s *migrator) verifyComments(verification *Verification, sourceIssue, targetIssue *jira.Issue, targetAttachments map[string]*jira.Attachment) {
+	sourceComments, err := getComments(s.sourceClient, sourceIssue.Key)
+	if err != nil {
+		verification.Errors = append(verification.Errors, err)
+		return
+	}
+
+	comments, err := getComments(s.targetClient, targetIssue.Key)
+	if err != nil {
+		verification.Errors = append(verification.Errors, err)
+		return
+	}
+
+	var targetComments []*jira.Comment
+	for _, comment := range comments {
+		if !s.isHistoryExport(sourceIssue.Key, comment.ID) {
+			targetComments = append(targetComments, comment)
+		}
+	}
+
+	if len(sourceComments) != len(targetComments) {
+		verification.differ("comments: expected %d, found %d", len(sourceComments), len(targetComments))
+	}
+
+	for i := 0; i < len(sourceComments) && i < len(targetComments); i++ {
+		if !strings.HasSuffix(normalizeText(targetComments[i].Body), normalizeText(s.expectedText(s.rewriteContent(sourceComments[i].Body), sourceIssue, targetAttachments))) {
+			verification.differ("comment %d: body of source comment %s differs from target comment %s", i+1, sourceComments[i].ID, targetComments[i].ID)
+		}
+	}
+}
+
+func (s *migrator) verifyAttachments(verification *Verification, sourceIssue, targetIssue *jira.Issue) {
+	var targetAttachments []*jira.Attachment
+	for _, targetAttachment := range targetIssue.Fields.Attachments {
+		if !s.isHistoryExport(sourceIssue.Key, targetAttachment.ID) {
+			targetAttachments = append(targetAttachments, targetAttachment)
+		}
+	}
+
+	if len(sourceIssue.Fields.Attachments) != len(targetAttachments) {
+		verification.differ("attachments: expected %d, found %d", len(sourceIssue.Fields.Attachments), len(targetAttachments))
+	}
+
+	for _, sourceAttachment := range sourceIssue.Fields.Attachments {
+		index := -1
+		for i, targetAttachment := range targetAttachments {
+			if targetAttachment.Filename == sourceAttachment.Filename && targetAttachment.Size == sourceAttachment.Size {
+				index = i
+				break
+			}
+		}
+
+		if index < 0 {
+			verification.differ("attachment %s (%d bytes): not found", sourceAttachment.Filename, sourceAttachment.Size)
+			continue
+		}
+
+		targetAttachment := targetAttachments[index]
+		targetAttachments = append(targetAttachments[:index], targetAttachments[index+1:]...)
+
+		if !s.verifyChecksums {
+			continue
+		}
+
+		sourceChecksum, err := getAttachmentChecksum(s.sourceClient, sourceAttachment.ID)
+		if err != nil {
+			verification.Errors = append(verification.Errors, err)
+			continue
+		}
+
+		targetChecksum, err := getAttachmentChecksum(s.targetClient, targetAttachment.ID)
+		if err != nil {
+			verification.Errors = append(verification.Errors, err)
+			continue
+		}
+
+		if sourceChecksum != targetChecksum {
+			verification.differ("attachment %s: checksum expected %s, found %s", sourceAttachment.Filename, sourceChecksum, targetChecksum)
+		}
+	}
+}
+
+func (s *migrator) verifyLinks(verification *Verification, sourceIssue, targetIssue *jira.Issue, getTargetKey func(string) string) {
+	targetLinks := map[string]bool{}
+	for _, link := range targetIssue.Fields.IssueLinks {
+		targetLinks[describeLink(link)] = true
+	}
+
+	for _, link := range sourceIssue.Fields.IssueLinks {
+		expectedLink := &jira.IssueLink{Type: link.Type}
+
+		switch {
+		case link.InwardIssue != nil:
+			expectedLink.InwardIssue = &jira.Issue{Key: getTargetKey(link.InwardIssue.Key)}
+		case link.OutwardIssue != nil:
+			expectedLink.OutwardIssue = &jira.Issue{Key: getTargetKey(link.OutwardIssue.Key)}
+		}
+
+		if (expectedLink.InwardIssue != nil && expectedLink.InwardIssue.Key == "") ||
+			(expectedLink.OutwardIssue != nil && expectedLink.OutwardIssue.Key == "") {
+			continue
+		}
+
+		if !targetLinks[describeLink(expectedLink)] {
+			verification.differ("link: %s (from %s) not found", describeLink(expectedLink), describeLink(link))
+		}
+	}
+}
+
+func (s *migrator) verifyParent(verification *Verification, sourceIssue, targetIssue *jira.Issue, getTargetKey func(string) string) {
+	sourceParentKey := s.getSourceParentKey(sourceIssue)
+	if sourceParentKey == "" {
+		return
+	}
+
+	expectedParentKey := getTargetKey(sourceParentKey)
+	if expectedParentKey == "" {
+		return
+	}
+
+	if targetParentKey := s.getTargetParentKey(targetIssue); targetParentKey != expectedParentKey {
+		verification.differ("parent: expected %s, found %s", expectedParentKey, targetParentKey)
+	}
+}
+
+func (s *migrator) verifySprint(verification *Verification, sourceIssue, targetIssue *jira.Issue) {
+	if !s.importSprints {
+		return
+	}
+
+	sourceSprint, err := getOpenSprint(s.getCustomFieldValue(sourceIssue, "Sprint"))
+	if err != nil {
+		verification.Errors = append(verification.Errors, err)
+		return
+	}
+
+	targetSprint, err := getOpenSprint(getFieldValueByName(s.targetFieldPerIssueType, targetIssue, "Sprint"))
+	if err != nil {
+		verification.Errors = append(verification.Errors, err)
+		return
+	}
+
+	if sourceSprint["name"] != targetSprint["name"] {
+		verification.differ("sprint: expected %v, found %v", sourceSprint["name"], targetSprint["name"])
+	}
+}
+
+func getAttachmentChecksum(client *jira.Client, attachmentID string) (string, error) {
+	response, err := client.Issue.DownloadAttachment(attachmentID)
+	if err != nil {
+		return "", parseResponseError("DownloadAttachment", response, err)
+	}
+	defer response.Body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, response.Body); err != nil {
+		return "", errors.Wrapf(err, "could not download attachment %s", attachmentID)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func describeLink(link *jira.IssueLink) string {
+	if link.InwardIssue != nil {
+		return fmt.Sprintf("%s %s", link.Type.Inward, link.InwardIssue.Key)
+	}
+
+	if link.OutwardIssue != nil {
+		return fmt.Sprintf("%s %s", link.Type.Outward, link.OutwardIssue.Key)
+	}
+
+	return link.Type.Name
+}
+
+func getPriorityName(priority *jira.Priority) string {
+	if priority == nil {
+		return "none"
+	}
+
+	return priority.Name
+}
+
+// expectedText returns a source text as migrated, with the attachment links and file names rewritten to the target
+// attachments, and the issue references rewritten when they are
+func (s *migrator) expectedText(text string, sourceIssue *jira.Issue, targetAttachments map[string]*jira.Attachment) string {
+	if len(targetAttachments) > 0 {
+		text = s.rewriteAttachmentLinks(text, sourceIssue, targetAttachments)
+	}
+
+	if !s.rewriteReferences {
+		return text
+	}
+
+	expected, _ := s.rewriteIssueKeys(text, sourceIssue.Key)
+	return expected
+}
+
+// getVerifiedAttachments returns the target attachment of every source attachment by source attachment ID, as
+// recorded in the ledger, or else the target attachment with the same file name and size
+func (s *migrator) getVerifiedAttachments(sourceIssue, targetIssue *jira.Issue) map[string]*jira.Attachment {
+	entry, _ := s.ledger.Get(sourceIssue.Key)
+	targetAttachments := map[string]*jira.Attachment{}
+
+	for _, sourceAttachment := range sourceIssue.Fields.Attachments {
+		if sourceAttachment == nil {
+			continue
+		}
+
+		if targetItem, ok := entry.Items[StepAttachments][sourceAttachment.ID]; ok {
+			targetAttachments[sourceAttachment.ID] = parseAttachmentItem(targetItem, sourceAttachment.Filename)
+			continue
+		}
+
+		for _, targetAttachment := range targetIssue.Fields.Attachments {
+			if targetAttachment.Filename == sourceAttachment.Filename && targetAttachment.Size == sourceAttachment.Size {
+				targetAttachments[sourceAttachment.ID] = targetAttachment
+				break
+			}
+		}
+	}
+
+	return targetAttachments
+}
+
+func normalizeText(text string) string {
+	return strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+}
+
+// normalizeFieldValue reduces a field value to what is comparable between instances, dropping IDs and URLs
+func normalizeFieldValue(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for _, key := range []string{"value", "name", "accountId"} {
+			if keyValue, ok := typedValue[key]; ok {
+				if child, ok := typedValue["child"]; ok {
+					return []interface{}{normalizeFieldValue(keyValue), normalizeFieldValue(child)}
+				}
+				return normalizeFieldValue(keyValue)
+			}
+		}
+
+		normalized := map[string]interface{}{}
+		for key, keyValue := range typedValue {
+			if key != "id" && key != "self" {
+				normalized[key] = normalizeFieldValue(keyValue)
+			}
+		}
+		return normalized
+	case []interface{}:
+		if len(typedValue) == 0 {
+			return nil
+		}
+
+		normalized := make([]interface{}, len(typedValue))
+		for i, item := range typedValue {
+			normalized[i] = normalizeFieldValue(item)
+		}
+		return normalized
+	case int:
+		return float64(typedValue)
+	default:
+		return value
+	}
+}
+
+func formatFieldValue(value interface{}) string {
+	if value == nil {
+		return "nothing"
+	}
+
+	formatted, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+
+	return string(formatted)
+}
@@ -0,0 +1,51 @@
+package migration
+
+import (
+	"testing"
+
+	"github.com/natenho/go-jira"
+	"github.com/trivago/tgo/tcontainer"
+)
+
+func TestVerifyFieldsCollapsesMappingsAndRewritesAttachments(t *testing.T) {
+	source := newFakeJira(t, nil)
+	target := newFakeJira(t, nil)
+	s := newTestMigrator(t, source, target)
+
+	estimate := jira.Field{Key: "customfield_3", Name: "Story point estimate"}
+	s.sourceTargetCustomFieldMap = map[string][]jira.Field{
+		"customfield_1": {estimate},
+		"customfield_2": {estimate},
+	}
+	s.targetFieldPerIssueType = map[string][]jira.Field{"Story": {estimate}}
+
+	sourceIssue := &jira.Issue{Key: "SRC-1", Fields: &jira.IssueFields{
+		Summary:     "Story",
+		Description: "See the attachment [" + source.server.URL + "/secure/attachment/100/a.txt] and !b.png!",
+		Type:        jira.IssueType{Name: "Story"},
+		Attachments: []*jira.Attachment{{ID: "100", Filename: "a.txt", Size: 3}, {ID: "101", Filename: "b.png", Size: 4}},
+		Unknowns:    tcontainer.MarshalMap{"customfield_1": nil, "customfield_2": 5.0},
+	}}
+
+	targetIssue := &jira.Issue{Key: "TGT-1", Fields: &jira.IssueFields{
+		Summary:     "Story",
+		Description: "See the attachment [" + target.server.URL + "/secure/attachment/200/a.txt] and !b (1).png!",
+		Type:        jira.IssueType{Name: "Story"},
+		Unknowns:    tcontainer.MarshalMap{"customfield_3": 5.0},
+	}}
+
+	if err := s.ledger.SetItem("SRC-1", StepAttachments, "100", "200/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ledger.SetItem("SRC-1", StepAttachments, "101", "201/b (1).png"); err != nil {
+		t.Fatal(err)
+	}
+
+	verification := Verification{SourceKey: "SRC-1", TargetKey: "TGT-1"}
+	s.verifyFields(&verification, sourceIssue, targetIssue, s.getVerifiedAttachments(sourceIssue, targetIssue))
+
+	if len(verification.Differences) > 0 {
+		t.Errorf("got differences %v, want none", verification.Differences)
+	}
+}
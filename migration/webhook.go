@@ -0,0 +1,220 @@
+package migration
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/natenho/go-jira"
+	"github.com/pkg/errors"
+)
+
+const (
+	WebhookIssueCreated      = "jira:issue_created"
+	WebhookIssueUpdated      = "jira:issue_updated"
+	WebhookCommentCreated    = "comment_created"
+	WebhookCommentUpdated    = "comment_updated"
+	WebhookAttachmentCreated = "attachment_created"
+)
+
+const webhookQueueSize = 1000
+
+var (
+	ErrWebhookEventIgnored = errors.New("webhook event ignored")
+	ErrWebhookQueueFull    = errors.New("webhook queue is full")
+)
+
+// WebhookEvent is the part of a Jira webhook payload needed to mirror the issue it is about
+type WebhookEvent struct {
+	Event      string           `json:"webhookEvent"`
+	Timestamp  int64            `json:"timestamp"`
+	Issue      *jira.Issue      `json:"issue,omitempty"`
+	Comment    *jira.Comment    `json:"comment,omitempty"`
+	Attachment *jira.Attachment `json:"attachment,omitempty"`
+}
+
+func ParseWebhookEvent(payload []byte) (WebhookEvent, error) {
+	event := WebhookEvent{}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return event, errors.Wrap(err, "could not parse webhook payload")
+	}
+
+	return event, nil
+}
+
+// IssueKey returns the key of the issue the event is about, which is empty for events without issue
+func (e WebhookEvent) IssueKey() string {
+	if e.Issue == nil {
+		return ""
+	}
+
+	return e.Issue.Key
+}
+
+// ProjectKey returns the project of the issue the event is about
+func (e WebhookEvent) ProjectKey() string {
+	if e.Issue == nil {
+		return ""
+	}
+
+	if e.Issue.Fields != nil && e.Issue.Fields.Project.Key != "" {
+		return e.Issue.Fields.Project.Key
+	}
+
+	projectKey, _, _ := strings.Cut(e.Issue.Key, "-")
+	return projectKey
+}
+
+func (e WebhookEvent) String() string {
+	return strings.TrimSpace(e.Event + " " + e.IssueKey())
+}
+
+// WebhookReceiver queues the issues of the webhook events received from the source instance and mirrors them to the target
+type WebhookReceiver struct {
+	migrator *migrator
+
+	queue   chan string
+	mutex   sync.Mutex
+	pending map[string]bool
+	stopped bool
+
+	results chan Result
+	workers *sync.WaitGroup
+}
+
+// ReceiveWebhooks starts mirroring the issues of the webhook events enqueued in the receiver
+func (s *migrator) ReceiveWebhooks() (*WebhookReceiver, error) {
+	sourceBoard, targetBoard, err := s.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	s.sync = true
+
+	run := LedgerRun{
+		ID:               s.runID,
+		Kind:             RunWebhook,
+		JQL:              RunWebhook,
+		SourceProjectKey: s.sourceProjectKey,
+		TargetProjectKey: s.targetProjectKey,
+		StartedAt:        time.Now().UTC(),
+	}
+
+	if err := s.ledger.StartRun(run); err != nil {
+		return nil, err
+	}
+
+	if err := s.migrateOpenSprints(sourceBoard.ID, targetBoard.ID); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Run %s receiving webhooks of %s to %s", s.runID, s.sourceProjectKey, s.targetProjectKey)
+
+	r := &WebhookReceiver{
+		migrator: s,
+		queue:    make(chan string, webhookQueueSize),
+		pending:  map[string]bool{},
+		results:  make(chan Result),
+		workers:  &sync.WaitGroup{},
+	}
+
+	for i := 0; i < s.workerPoolSize; i++ {
+		r.workers.Add(1)
+		go r.worker()
+	}
+
+	go func() {
+		r.workers.Wait()
+		if err := s.ledger.FinishRun(s.runID); err != nil {
+			log.Println(err)
+		}
+		close(r.results)
+	}()
+
+	return r, nil
+}
+
+// Enqueue queues the issue of the event to be mirrored, unless the same issue is already waiting in the queue
+func (r *WebhookReceiver) Enqueue(event WebhookEvent) error {
+	switch event.Event {
+	case WebhookIssueCreated, WebhookIssueUpdated, WebhookCommentCreated, WebhookCommentUpdated, WebhookAttachmentCreated:
+	default:
+		return errors.Wrapf(ErrWebhookEventIgnored, "unsupported event %q", event.Event)
+	}
+
+	issueKey := event.IssueKey()
+	if issueKey == "" {
+		return errors.Wrapf(ErrWebhookEventIgnored, "%s has no issue, the issue_updated event sent with it is mirrored instead", event.Event)
+	}
+
+	if projectKey := event.ProjectKey(); projectKey != r.migrator.sourceProjectKey {
+		return errors.Wrapf(ErrWebhookEventIgnored, "%s does not belong to %s", issueKey, r.migrator.sourceProjectKey)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped {
+		return errors.Wrap(ErrWebhookEventIgnored, "receiver stopped")
+	}
+
+	if r.pending[issueKey] {
+		return nil
+	}
+
+	select {
+	case r.queue <- issueKey:
+		r.pending[issueKey] = true
+		return nil
+	default:
+		// The issue is missed by this run, unless a later event of it is received
+		if err := r.migrator.ledger.SetRunIssueFailed(r.migrator.runID, issueKey, true); err != nil {
+			log.Println(err)
+		}
+
+		return ErrWebhookQueueFull
+	}
+}
+
+// Results returns the outcome of every issue mirrored, it is closed once the receiver stops
+func (r *WebhookReceiver) Results() <-chan Result {
+	return r.results
+}
+
+// Stop rejects new events, the issues already queued are still mirrored
+func (r *WebhookReceiver) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped {
+		return
+	}
+
+	r.stopped = true
+	close(r.queue)
+}
+
+func (r *WebhookReceiver) worker() {
+	defer r.workers.Done()
+
+	for issueKey := range r.queue {
+		// Events received from now on need another migration, as they may not be seen by this one
+		r.mutex.Lock()
+		delete(r.pending, issueKey)
+		r.mutex.Unlock()
+
+		result := r.migrator.migrateIssue(issueKey)
+
+		if err := r.migrator.ledger.SetRunIssueFailed(r.migrator.runID, issueKey, len(result.Errors) > 0); err != nil {
+			result.Errors = append(result.Errors, err)
+		}
+
+		if err := r.migrator.rewriteRunReferences(); err != nil {
+			log.Println(err)
+		}
+
+		r.results <- result
+	}
+}
@@ -0,0 +1,164 @@
+package migration
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/natenho/go-jira"
+	"github.com/pkg/errors"
+)
+
+// worklog is the time logged on an issue, with the visibility missing from jira.WorklogRecord
+type worklog struct {
+	ID               string                  `json:"id,omitempty"`
+	Author           *jira.User              `json:"author,omitempty"`
+	Comment          string                  `json:"comment,omitempty"`
+	Started          string                  `json:"started"`
+	TimeSpentSeconds int                     `json:"timeSpentSeconds"`
+	Visibility       *jira.CommentVisibility `json:"visibility,omitempty"`
+}
+
+type worklogPage struct {
+	StartAt    int        `json:"startAt"`
+	MaxResults int        `json:"maxResults"`
+	Total      int        `json:"total"`
+	Worklogs   []*worklog `json:"worklogs"`
+}
+
+// getWorklogs reads every worklog of an issue, as the worklogs of the issue itself are truncated
+func getWorklogs(client *jira.Client, issueKey string) ([]*worklog, error) {
+	var worklogs []*worklog
+
+	for {
+		request, err := client.NewRequest(http.MethodGet, fmt.Sprintf("rest/api/2/issue/%s/worklog?startAt=%d&maxResults=%d", url.PathEscape(issueKey), len(worklogs), maxResultsPerSearch), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		page := &worklogPage{}
+		response, err := client.Do(request, page)
+		if err != nil {
+			return nil, parseResponseError("GetWorklogs", response, err)
+		}
+		response.Body.Close()
+
+		worklogs = append(worklogs, page.Worklogs...)
+
+		if len(page.Worklogs) == 0 || len(worklogs) >= page.Total {
+			return worklogs, nil
+		}
+	}
+}
+
+// addWorklog logs time on an issue without changing its remaining estimate, which is copied from the source
+func addWorklog(client *jira.Client, issueID string, record *worklog) (*worklog, error) {
+	request, err := client.NewRequest(http.MethodPost, fmt.Sprintf("rest/api/2/issue/%s/worklog?adjustEstimate=leave", url.PathEscape(issueID)), record)
+	if err != nil {
+		return nil, err
+	}
+
+	createdWorklog := &worklog{}
+	response, err := client.Do(request, createdWorklog)
+	if err != nil {
+		return nil, parseResponseError("AddWorklog", response, err)
+	}
+	defer response.Body.Close()
+
+	return createdWorklog, nil
+}
+
+// migrateWorklogs logs the time of the source worklogs on the target issue, skipping the ones migrated by earlier
+// runs, then copies the source estimates so the time tracking totals of both issues match
+func (s *migrator) migrateWorklogs(sourceIssue *jira.Issue, targetIssue *jira.Issue) chan error {
+	worklogs, err := getWorklogs(s.sourceClient, sourceIssue.Key)
+	if err != nil {
+		errChan := make(chan error, 1)
+		errChan <- err
+		close(errChan)
+		return errChan
+	}
+
+	errChan := make(chan error, len(worklogs)+1)
+	defer close(errChan)
+
+	for _, item := range worklogs {
+		if s.ledger.IsItemDone(sourceIssue.Key, StepWorklogs, item.ID) {
+			continue
+		}
+
+		createdWorklog, err := addWorklog(s.targetClient, targetIssue.ID, &worklog{
+			Comment:          s.getWorklogComment(item),
+			Started:          item.Started,
+			TimeSpentSeconds: item.TimeSpentSeconds,
+			Visibility:       s.mapVisibility(visibilityOf(item.Visibility)),
+		})
+		if err == nil {
+			err = s.ledger.SetItem(sourceIssue.Key, StepWorklogs, item.ID, createdWorklog.ID)
+		}
+
+		if err != nil {
+			errChan <- errors.Wrapf(err, "worklog %s", item.ID)
+		}
+	}
+
+	errChan <- s.migrateEstimates(sourceIssue, targetIssue)
+	return errChan
+}
+
+// getWorklogComment returns the comment of a source worklog, noting its author when the target worklog cannot be
+// logged by the same user, as every worklog is logged by the migration user
+func (s *migrator) getWorklogComment(item *worklog) string {
+	comment := s.rewriteContent(item.Comment)
+
+	if item.Author != nil {
+		if targetUser := s.mapUser(item.Author); targetUser != nil && s.currentUser != nil && userID(targetUser) == userID(s.currentUser) {
+			return comment
+		}
+	}
+
+	header := fmt.Sprintf("_Logged by %s_", s.mention(item.Author))
+	if comment == "" {
+		return header
+	}
+
+	return header + "\n\n" + comment
+}
+
+// migrateEstimates copies the original and remaining estimates of the source issue, the time spent being the sum
+// of the worklogs
+func (s *migrator) migrateEstimates(sourceIssue *jira.Issue, targetIssue *jira.Issue) error {
+	timeTracking := sourceIssue.Fields.TimeTracking
+	if timeTracking == nil || !s.canMigrateField(sourceIssue.Fields.Type.Name, "timetracking") {
+		return nil
+	}
+
+	estimates := map[string]interface{}{}
+	if timeTracking.OriginalEstimateSeconds > 0 {
+		estimates["originalEstimate"] = fmt.Sprintf("%dm", timeTracking.OriginalEstimateSeconds/60)
+	}
+	if timeTracking.RemainingEstimate != "" {
+		estimates["remainingEstimate"] = fmt.Sprintf("%dm", timeTracking.RemainingEstimateSeconds/60)
+	}
+
+	if len(estimates) == 0 {
+		return nil
+	}
+
+	response, err := s.targetClient.Issue.UpdateIssue(targetIssue.Key, map[string]interface{}{"fields": map[string]interface{}{"timetracking": estimates}})
+	if err != nil {
+		return parseResponseError("UpdateIssue", response, err)
+	}
+	defer response.Body.Close()
+
+	return nil
+}
+
+// visibilityOf returns the visibility of an item, which is visible to everyone when it has none
+func visibilityOf(visibility *jira.CommentVisibility) jira.CommentVisibility {
+	if visibility == nil {
+		return jira.CommentVisibility{}
+	}
+
+	return *visibility
+}
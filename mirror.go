@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/natenho/go-jira-migrate/migration"
+	"github.com/pkg/errors"
+)
+
+func runMirror(flags *flag.FlagSet, args []string) error {
+	configFlags := newConfigFlags(flags)
+	configFlags.addConnectionFlags()
+	configFlags.addProjectFlags()
+	configFlags.addMigrationFlags()
+	var output = addOutputFlags(flags)
+	var interval = flags.Duration("interval", 5*time.Minute, "How long to wait between polls of the source projects")
+	var healthAddress = flags.String("health", ":8080", "Address of the HTTP health endpoint (GET /health), disabled when empty")
+
+	cfg, err := configFlags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.validateConnection(); err != nil {
+		flags.Usage()
+		return err
+	}
+
+	if err := cfg.validateMigration(); err != nil {
+		flags.Usage()
+		return err
+	}
+
+	if *interval <= 0 {
+		flags.Usage()
+		return errors.Errorf("interval must be greater than zero, found %s", *interval)
+	}
+
+	ledger, err := migration.OpenLedger(cfg.Ledger)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+
+	transport := cfg.newTransport()
+
+	writer, err := output.open()
+	if err != nil {
+		return err
+	}
+	defer closeResultWriter(writer)
+
+	var mirrors []*migration.Mirror
+	stopAll := func() {
+		for _, mirror := range mirrors {
+			mirror.Stop()
+		}
+	}
+
+	for _, project := range cfg.Projects {
+		migrator, err := newMigrator(cfg, ledger, transport, project)
+		if err != nil {
+			stopAll()
+			return err
+		}
+
+		mirror, err := migrator.Mirror(project.query(cfg), *interval)
+		if err != nil {
+			stopAll()
+			return err
+		}
+
+		mirrors = append(mirrors, mirror)
+	}
+
+	if *healthAddress != "" {
+		go serveHealth(*healthAddress, mirrors)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("Stopping after the current poll...")
+		stopAll()
+	}()
+
+	wg := &sync.WaitGroup{}
+	for _, mirror := range mirrors {
+		wg.Add(1)
+		go func(mirror *migration.Mirror) {
+			defer wg.Done()
+			for result := range mirror.Results() {
+				log.Println(result)
+				if err := writer.Write(result); err != nil {
+					log.Printf("Could not write output: %s", err)
+				}
+			}
+		}(mirror)
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// serveHealth reports the health of every mirror, answering 503 when any of them is unhealthy
+func serveHealth(address string, mirrors []*migration.Mirror) {
+	handler := http.NewServeMux()
+	handler.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		healths := make([]migration.MirrorHealth, 0, len(mirrors))
+		for _, mirror := range mirrors {
+			health := mirror.Health()
+			if !health.IsHealthy() {
+				status = http.StatusServiceUnavailable
+			}
+			healths = append(healths, health)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(healths)
+	})
+
+	log.Printf("Serving health on %s/health", address)
+	if err := http.ListenAndServe(address, handler); err != nil {
+		log.Printf("Could not serve health: %s", err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/natenho/go-jira-migrate/migration"
+	"github.com/pkg/errors"
+)
+
+const (
+	outputFormatJSONLines = "jsonl"
+	outputFormatCSV       = "csv"
+)
+
+// resultWriter writes the results of a command in a machine-readable format
+type resultWriter interface {
+	Write(result migration.Result) error
+	Close() error
+}
+
+// outputFlags selects where the results of a command are written, besides the log
+type outputFlags struct {
+	path   *string
+	format *string
+}
+
+func addOutputFlags(flags *flag.FlagSet) *outputFlags {
+	return &outputFlags{
+		path:   flags.String("output", "", "File receiving a record per issue with the outcome of every step ('-' for stdout)"),
+		format: flags.String("output-format", "", "Format of the output file, 'jsonl' or 'csv' (defaults to the file extension, or 'jsonl')"),
+	}
+}
+
+// open creates the output file, the results are discarded when no file was given
+func (o *outputFlags) open() (resultWriter, error) {
+	if *o.path == "" {
+		return discardWriter{}, nil
+	}
+
+	format := strings.ToLower(*o.format)
+	if format == "" {
+		format = outputFormatJSONLines
+		if strings.EqualFold(filepath.Ext(*o.path), ".csv") {
+			format = outputFormatCSV
+		}
+	}
+
+	if format != outputFormatJSONLines && format != outputFormatCSV {
+		return nil, errors.Errorf("unknown output format %q, use %q or %q", format, outputFormatJSONLines, outputFormatCSV)
+	}
+
+	var file io.WriteCloser = nopCloser{os.Stdout}
+	if *o.path != "-" {
+		var err error
+		file, err = os.Create(*o.path)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create output")
+		}
+	}
+
+	if format == outputFormatCSV {
+		writer, err := newCSVResultWriter(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		return &lockedResultWriter{writer: writer}, nil
+	}
+
+	return &lockedResultWriter{writer: &jsonResultWriter{file: file, encoder: json.NewEncoder(file)}}, nil
+}
+
+// resultRecord is the machine-readable form of a migration result
+type resultRecord struct {
+	SourceKey string                 `json:"sourceKey"`
+	TargetKey string                 `json:"targetKey,omitempty"`
+	IssueType string                 `json:"issueType,omitempty"`
+	Summary   string                 `json:"summary,omitempty"`
+	Result    string                 `json:"result"`
+	Steps     []migration.StepResult `json:"steps,omitempty"`
+	// Comments are the outcome of every source comment
+	Comments   []migration.CommentResult `json:"comments,omitempty"`
+	DurationMs int64                     `json:"durationMs"`
+	Errors     []string                  `json:"errors,omitempty"`
+	// ErrorKinds are the kinds of the errors, such as rate-limited or permission-denied
+	ErrorKinds []string `json:"errorKinds,omitempty"`
+}
+
+func newResultRecord(result migration.Result) resultRecord {
+	record := resultRecord{
+		SourceKey:  result.SourceKey,
+		TargetKey:  result.TargetKey,
+		IssueType:  result.IssueType,
+		Summary:    result.SourceSummary,
+		Result:     "ok",
+		Steps:      result.Steps,
+		Comments:   result.Comments,
+		DurationMs: result.Duration.Milliseconds(),
+	}
+
+	for _, err := range result.Errors {
+		record.Result = "failed"
+		record.Errors = append(record.Errors, err.Error())
+		record.ErrorKinds = append(record.ErrorKinds, migration.ErrorKind(err))
+	}
+
+	return record
+}
+
+type jsonResultWriter struct {
+	file    io.WriteCloser
+	encoder *json.Encoder
+}
+
+func (w *jsonResultWriter) Write(result migration.Result) error {
+	return w.encoder.Encode(newResultRecord(result))
+}
+
+func (w *jsonResultWriter) Close() error {
+	return w.file.Close()
+}
+
+// csvResultWriter writes a row per issue, with a column per step and the item counts of the steps migrating items
+type csvResultWriter struct {
+	file   io.WriteCloser
+	writer *csv.Writer
+	steps  []string
+}
+
+func newCSVResultWriter(file io.WriteCloser) (*csvResultWriter, error) {
+	w := &csvResultWriter{
+		file:   file,
+		writer: csv.NewWriter(file),
+		steps:  append(migration.MigrationSteps(), migration.StepFields),
+	}
+
+	header := []string{"source_key", "target_key", "issue_type", "summary", "result"}
+	for _, step := range w.steps {
+		header = append(header, "step_"+step)
+		switch step {
+		case migration.StepComments, migration.StepWorklogs, migration.StepLinks:
+			header = append(header, step+"_migrated", step+"_total")
+		case migration.StepAttachments:
+			header = append(header, step+"_migrated", step+"_total", step+"_bytes")
+		}
+	}
+	header = append(header, "duration_ms", "errors", "error_kinds")
+
+	if err := w.writer.Write(header); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *csvResultWriter) Write(result migration.Result) error {
+	record := newResultRecord(result)
+
+	row := []string{record.SourceKey, record.TargetKey, record.IssueType, record.Summary, record.Result}
+	for _, name := range w.steps {
+		step := result.Step(name)
+		row = append(row, step.Status)
+		switch name {
+		case migration.StepComments, migration.StepWorklogs, migration.StepLinks:
+			row = append(row, strconv.Itoa(step.Migrated), strconv.Itoa(step.Total))
+		case migration.StepAttachments:
+			row = append(row, strconv.Itoa(step.Migrated), strconv.Itoa(step.Total), strconv.FormatInt(step.Bytes, 10))
+		}
+	}
+	row = append(row, strconv.FormatInt(record.DurationMs, 10), strings.Join(record.Errors, "\n"), strings.Join(record.ErrorKinds, "\n"))
+
+	if err := w.writer.Write(row); err != nil {
+		return err
+	}
+
+	// Rows are flushed one by one, so the file can be followed while the command runs
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvResultWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+func closeResultWriter(writer resultWriter) {
+	if err := writer.Close(); err != nil {
+		log.Printf("Could not write output: %s", err)
+	}
+}
+
+// lockedResultWriter serializes the results written by the goroutines of several projects
+type lockedResultWriter struct {
+	mutex  sync.Mutex
+	writer resultWriter
+}
+
+func (w *lockedResultWriter) Write(result migration.Result) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.writer.Write(result)
+}
+
+func (w *lockedResultWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.writer.Close()
+}
+
+type discardWriter struct{}
+
+func (discardWriter) Write(migration.Result) error { return nil }
+
+func (discardWriter) Close() error { return nil }
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
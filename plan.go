@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/natenho/go-jira-migrate/migration"
+)
+
+func runPlan(flags *flag.FlagSet, args []string) error {
+	configFlags := newConfigFlags(flags)
+	configFlags.addConnectionFlags()
+	configFlags.addProjectFlags()
+	configFlags.addMigrationFlags()
+	var asJSON = flags.Bool("json", false, "Print the plan as JSON")
+
+	cfg, err := configFlags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.validateConnection(); err != nil {
+		flags.Usage()
+		return err
+	}
+
+	if err := cfg.validateMigration(); err != nil {
+		flags.Usage()
+		return err
+	}
+
+	ledger, err := migration.OpenLedger(cfg.Ledger)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+
+	transport := cfg.newTransport()
+
+	var unmappedUsers []migration.UnmappedUser
+
+	for _, project := range cfg.Projects {
+		migrator, err := newMigrator(cfg, ledger, transport, project)
+		if err != nil {
+			return err
+		}
+
+		plan, err := migrator.Plan(project.query(cfg))
+		if err != nil {
+			return err
+		}
+
+		unmappedUsers = mergeUnmappedUsers(unmappedUsers, migrator.UnmappedUsers())
+
+		if *asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(plan); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fmt.Print(plan)
+	}
+
+	reportUnmappedUsers(cfg, unmappedUsers)
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+func runReplay(flags *flag.FlagSet, args []string) error {
+	configFlags := newConfigFlags(flags)
+	configFlags.String("secret", "Secret shared with the webhook receiver, used to sign the payloads", func(cfg *Config) *string { return &cfg.Webhook.Secret })
+	var receiverURL = flags.String("url", "http://localhost:8080/webhook", "URL of the webhook receiver")
+
+	cfg, err := configFlags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("at least one file with recorded webhook payloads is required")
+	}
+
+	var sent, failed int
+	for _, path := range flags.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, "could not open recorded webhooks")
+		}
+
+		// A file holds a single payload or a sequence of them, such as JSON Lines
+		decoder := json.NewDecoder(file)
+		for {
+			var payload json.RawMessage
+			if err := decoder.Decode(&payload); err == io.EOF {
+				break
+			} else if err != nil {
+				file.Close()
+				return errors.Wrapf(err, "could not read %s", path)
+			}
+
+			if err := replayWebhook(*receiverURL, cfg.Webhook.Secret, payload); err != nil {
+				log.Printf("%s: %s", path, err)
+				failed++
+				continue
+			}
+
+			sent++
+		}
+
+		file.Close()
+	}
+
+	log.Printf("%d webhooks replayed, %d failed.", sent, failed)
+
+	if failed > 0 {
+		return errors.Errorf("%d webhooks failed", failed)
+	}
+
+	return nil
+}
+
+func replayWebhook(receiverURL, secret string, payload []byte) error {
+	request, err := http.NewRequest(http.MethodPost, receiverURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		request.Header.Set(webhookSignatureHeader, signWebhookPayload(secret, payload))
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		body, _ := io.ReadAll(response.Body)
+		return errors.Errorf("%s: %s", response.Status, bytes.TrimSpace(body))
+	}
+
+	return nil
+}
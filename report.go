@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/natenho/go-jira-migrate/migration"
+)
+
+func runReport(flags *flag.FlagSet, args []string) error {
+	configFlags := newConfigFlags(flags)
+	configFlags.addLedgerFlag()
+	var runID = flags.String("run", "", "ID of the run to detail ('last' for the most recent run), all runs are summarized when empty")
+
+	cfg, err := configFlags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	ledger, err := migration.OpenLedger(cfg.Ledger)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+
+	runs := ledger.Runs()
+
+	if *runID == "last" && len(runs) > 0 {
+		*runID = runs[len(runs)-1].ID
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer writer.Flush()
+
+	if *runID == "" {
+		reportRuns(writer, ledger, runs)
+		return nil
+	}
+
+	reportRunIssues(writer, ledger, *runID)
+	return nil
+}
+
+func reportRuns(writer *tabwriter.Writer, ledger *migration.Ledger, runs []migration.LedgerRun) {
+	issuesPerRun := map[string]int{}
+	failuresPerRun := map[string]int{}
+	for _, entry := range ledger.Entries() {
+		issuesPerRun[entry.RunID]++
+		if !entry.IsComplete() {
+			failuresPerRun[entry.RunID]++
+		}
+	}
+
+	fmt.Fprintln(writer, "RUN\tSOURCE\tTARGET\tSTARTED\tFINISHED\tISSUES\tINCOMPLETE\tQUERY")
+	for _, run := range runs {
+		finished := "unfinished"
+		if run.IsFinished() {
+			finished = run.FinishedAt.Local().Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+			run.ID,
+			run.SourceProjectKey,
+			run.TargetProjectKey,
+			run.StartedAt.Local().Format(time.RFC3339),
+			finished,
+			issuesPerRun[run.ID],
+			failuresPerRun[run.ID],
+			run.JQL)
+	}
+}
+
+func reportRunIssues(writer *tabwriter.Writer, ledger *migration.Ledger, runID string) {
+	fmt.Fprintln(writer, "SOURCE\tTARGET\tFAILED STEPS\tPENDING STEPS")
+	for _, entry := range ledger.Entries() {
+		if entry.RunID != runID {
+			continue
+		}
+
+		var failed, pending []string
+		for _, step := range migration.MigrationSteps() {
+			switch entry.Steps[step] {
+			case migration.StepStatusDone:
+			case migration.StepStatusFailed:
+				failed = append(failed, step)
+			default:
+				pending = append(pending, step)
+			}
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", entry.SourceKey, entry.TargetKey, strings.Join(failed, ","), strings.Join(pending, ","))
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/natenho/go-jira-migrate/migration"
+	"github.com/pkg/errors"
+)
+
+func runRollback(flags *flag.FlagSet, args []string) error {
+	configFlags := newConfigFlags(flags)
+	configFlags.addConnectionFlags()
+	var runID = flags.String("run", "", "ID of the run to roll back ('last' for the most recent run)")
+	var dryRun = flags.Bool("dry-run", false, "Print what would be deleted without deleting anything")
+	var yes = flags.Bool("yes", false, "Do not ask for confirmation")
+
+	cfg, err := configFlags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.validateConnection(); err != nil {
+		flags.Usage()
+		return err
+	}
+
+	ledger, err := migration.OpenLedger(cfg.Ledger)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+
+	transport := cfg.newTransport()
+
+	migrator, err := newMigrator(cfg, ledger, transport, ProjectConfig{})
+	if err != nil {
+		return err
+	}
+
+	if *runID == "last" {
+		runs := ledger.Runs()
+		if len(runs) == 0 {
+			return errors.New("no runs found in the ledger")
+		}
+		*runID = runs[len(runs)-1].ID
+	}
+
+	if *runID == "" {
+		flags.Usage()
+		return errors.New("invalid run ID")
+	}
+
+	actions, err := migrator.Rollback(*runID, true)
+	if err != nil {
+		return err
+	}
+
+	for _, action := range actions {
+		fmt.Println(action)
+	}
+
+	if *dryRun {
+		log.Printf("%d objects would be deleted.", len(actions))
+		return nil
+	}
+
+	if !*yes && !confirm(fmt.Sprintf("Delete %d objects created by run %s?", len(actions), *runID)) {
+		return nil
+	}
+
+	actions, err = migrator.Rollback(*runID, false)
+	if err != nil {
+		return err
+	}
+
+	var failures int
+	for _, action := range actions {
+		if action.Error != nil {
+			log.Println(action)
+			failures++
+		}
+	}
+
+	log.Printf("%d objects deleted, %d failed.", len(actions)-failures, failures)
+
+	if failures > 0 {
+		return errors.Errorf("could not delete %d objects, run the rollback again to retry", failures)
+	}
+
+	return nil
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/natenho/go-jira-migrate/migration"
+	"github.com/pkg/errors"
+)
+
+// loadUserMappingFile adds the mappings of the user mapping file to the users, the users of the config file take precedence
+func (c *Config) loadUserMappingFile() error {
+	if c.UserMapping.File == "" {
+		return nil
+	}
+
+	mappings, err := readUserMappingFile(c.UserMapping.File)
+	if err != nil {
+		return err
+	}
+
+	if c.Users == nil {
+		c.Users = map[string]string{}
+	}
+
+	for sourceUserID, targetUserID := range mappings {
+		if _, ok := c.Users[sourceUserID]; !ok {
+			c.Users[sourceUserID] = targetUserID
+		}
+	}
+
+	return nil
+}
+
+// readUserMappingFile reads a JSON object of source to target users, or a CSV file with source and target columns.
+// Rows without a target are skipped, so the unmapped users report can be completed and used as a mapping file.
+func readUserMappingFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read user mapping")
+	}
+	defer file.Close()
+
+	mappings := map[string]string{}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.NewDecoder(file).Decode(&mappings); err != nil {
+			return nil, errors.Wrapf(err, "%s", path)
+		}
+		return mappings, nil
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: could not read header", path)
+	}
+
+	sourceColumn, targetColumn := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "source":
+			sourceColumn = i
+		case "target":
+			targetColumn = i
+		}
+	}
+
+	if sourceColumn < 0 || targetColumn < 0 {
+		return nil, errors.Errorf("%s: source and target columns are required", path)
+	}
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", path)
+		}
+
+		if sourceColumn >= len(row) || targetColumn >= len(row) {
+			continue
+		}
+
+		sourceUserID, targetUserID := strings.TrimSpace(row[sourceColumn]), strings.TrimSpace(row[targetColumn])
+		if sourceUserID != "" && targetUserID != "" {
+			mappings[sourceUserID] = targetUserID
+		}
+	}
+
+	return mappings, nil
+}
+
+// reportUnmappedUsers logs the source users without a target user and writes them to the report file, if any
+func reportUnmappedUsers(cfg *Config, users []migration.UnmappedUser) {
+	for _, user := range users {
+		log.Printf("User %s (%s) is not mapped: %s", user.DisplayName, user.ID, user.Reason)
+	}
+
+	if cfg.UserMapping.Report == "" {
+		return
+	}
+
+	if err := writeUnmappedUsers(cfg.UserMapping.Report, users); err != nil {
+		log.Printf("Could not write unmapped users: %s", err)
+		return
+	}
+
+	log.Printf("%d unmapped users written to %s.", len(users), cfg.UserMapping.Report)
+}
+
+// writeUnmappedUsers writes the unmapped users in the CSV format of the user mapping file, with an empty target column
+func writeUnmappedUsers(path string, users []migration.UnmappedUser) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	_ = writer.Write([]string{"source", "target", "displayName", "emailAddress", "reason"})
+
+	for _, user := range users {
+		_ = writer.Write([]string{user.ID, "", user.DisplayName, user.EmailAddress, user.Reason})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// mergeUnmappedUsers adds the unmapped users of a project to the ones of the previous projects
+func mergeUnmappedUsers(users []migration.UnmappedUser, projectUsers []migration.UnmappedUser) []migration.UnmappedUser {
+	for _, projectUser := range projectUsers {
+		found := false
+		for _, user := range users {
+			if user.ID == projectUser.ID {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			users = append(users, projectUser)
+		}
+	}
+
+	return users
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/natenho/go-jira-migrate/migration"
+	"github.com/pkg/errors"
+)
+
+func runVerify(flags *flag.FlagSet, args []string) error {
+	configFlags := newConfigFlags(flags)
+	configFlags.addConnectionFlags()
+	configFlags.addProjectFlags()
+	configFlags.addMigrationFlags()
+	var checksums = flags.Bool("checksums", true, "Download the attachments of both issues to compare their checksums")
+
+	cfg, err := configFlags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.validateConnection(); err != nil {
+		flags.Usage()
+		return err
+	}
+
+	if err := cfg.validateMigration(); err != nil {
+		flags.Usage()
+		return err
+	}
+
+	ledger, err := migration.OpenLedger(cfg.Ledger)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+
+	transport := cfg.newTransport()
+
+	var issueCount, mismatchCount int
+
+	for _, project := range cfg.Projects {
+		migrator, err := newMigrator(cfg, ledger, transport, project, migration.WithChecksums(*checksums))
+		if err != nil {
+			return err
+		}
+
+		verifications, err := migrator.Verify(project.query(cfg))
+		if err != nil {
+			return err
+		}
+
+		for verification := range verifications {
+			fmt.Println(verification)
+			issueCount++
+			if !verification.OK() {
+				mismatchCount++
+			}
+		}
+	}
+
+	log.Printf("%d issues verified, %d do not match.", issueCount, mismatchCount)
+
+	if mismatchCount > 0 {
+		return errors.Errorf("%d issues do not match", mismatchCount)
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/natenho/go-jira-migrate/migration"
+	"github.com/pkg/errors"
+)
+
+const (
+	webhookSignatureHeader = "X-Hub-Signature"
+	maxWebhookPayloadSize  = 10 << 20
+)
+
+func runWebhook(flags *flag.FlagSet, args []string) error {
+	configFlags := newConfigFlags(flags)
+	configFlags.addConnectionFlags()
+	configFlags.addProjectFlags()
+	configFlags.addMigrationFlags()
+	var output = addOutputFlags(flags)
+	configFlags.String("listen", "Address receiving the webhooks of the source instance on /webhook", func(cfg *Config) *string { return &cfg.Webhook.Listen })
+	configFlags.String("secret", "Secret shared with the source webhook, which signs the payloads with it or sends it as the 'secret' query parameter", func(cfg *Config) *string { return &cfg.Webhook.Secret })
+
+	cfg, err := configFlags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.validateConnection(); err != nil {
+		flags.Usage()
+		return err
+	}
+
+	if err := cfg.validateMigration(); err != nil {
+		flags.Usage()
+		return err
+	}
+
+	if cfg.Webhook.Secret == "" {
+		flags.Usage()
+		return errors.New("webhook.secret: is required (-secret)")
+	}
+
+	ledger, err := migration.OpenLedger(cfg.Ledger)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+
+	transport := cfg.newTransport()
+
+	writer, err := output.open()
+	if err != nil {
+		return err
+	}
+	defer closeResultWriter(writer)
+
+	var receivers []*migration.WebhookReceiver
+	stopAll := func() {
+		for _, receiver := range receivers {
+			receiver.Stop()
+		}
+	}
+
+	for _, project := range cfg.Projects {
+		migrator, err := newMigrator(cfg, ledger, transport, project)
+		if err != nil {
+			stopAll()
+			return err
+		}
+
+		receiver, err := migrator.ReceiveWebhooks()
+		if err != nil {
+			stopAll()
+			return err
+		}
+
+		receivers = append(receivers, receiver)
+	}
+
+	handler := http.NewServeMux()
+	handler.Handle("/webhook", webhookHandler(cfg.Webhook.Secret, receivers))
+
+	server := &http.Server{Addr: cfg.Webhook.Listen, Handler: handler}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("Stopping after the queued issues...")
+		_ = server.Close()
+	}()
+
+	wg := &sync.WaitGroup{}
+	for _, receiver := range receivers {
+		wg.Add(1)
+		go func(receiver *migration.WebhookReceiver) {
+			defer wg.Done()
+			for result := range receiver.Results() {
+				log.Println(result)
+				if err := writer.Write(result); err != nil {
+					log.Printf("Could not write output: %s", err)
+				}
+			}
+		}(receiver)
+	}
+
+	log.Printf("Receiving webhooks on %s/webhook", cfg.Webhook.Listen)
+	err = server.ListenAndServe()
+	stopAll()
+	wg.Wait()
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// webhookHandler verifies the webhook payloads and enqueues them in the receiver of their project
+func webhookHandler(secret string, receivers []*migration.WebhookReceiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookPayloadSize))
+		if err != nil {
+			http.Error(w, "could not read payload", http.StatusBadRequest)
+			return
+		}
+
+		if !isWebhookAuthorized(secret, r, payload) {
+			log.Printf("Rejected webhook from %s: invalid secret", r.RemoteAddr)
+			http.Error(w, "invalid secret", http.StatusUnauthorized)
+			return
+		}
+
+		event, err := migration.ParseWebhookEvent(payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, receiver := range receivers {
+			err = receiver.Enqueue(event)
+			if !errors.Is(err, migration.ErrWebhookEventIgnored) {
+				break
+			}
+		}
+
+		switch {
+		case err == nil:
+			log.Printf("Queued %s", event)
+			w.WriteHeader(http.StatusAccepted)
+		case errors.Is(err, migration.ErrWebhookEventIgnored):
+			log.Printf("Ignored %s: %s", event, err)
+			w.WriteHeader(http.StatusOK)
+		case errors.Is(err, migration.ErrWebhookQueueFull):
+			log.Printf("Could not queue %s: %s", event, err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// isWebhookAuthorized accepts payloads signed with the secret, or sent with the secret as query parameter
+// by instances that cannot sign webhooks
+func isWebhookAuthorized(secret string, r *http.Request, payload []byte) bool {
+	if signature := r.Header.Get(webhookSignatureHeader); signature != "" {
+		return hmac.Equal([]byte(signature), []byte(signWebhookPayload(secret, payload)))
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("secret")), []byte(secret)) == 1
+}
+
+// signWebhookPayload returns the signature header value of the payload, the way Jira signs webhooks
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return fmt.Sprintf("sha256=%s", strings.ToLower(hex.EncodeToString(mac.Sum(nil))))
+}